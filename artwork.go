@@ -0,0 +1,100 @@
+package itunes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrNoArtwork is returned by DownloadArtwork for shows that
+// aren't in the store or have no artwork.
+var ErrNoArtwork = errors.New("no artwork found")
+
+// Matches the size and format at the end of an artwork URL,
+// either rendered or as a template.
+// e.g. https://is1-ssl.mzstatic.com/image/thumb/Podcasts/.../600x600bb.jpg
+// or https://is1-ssl.mzstatic.com/image/thumb/Podcasts/.../{w}x{h}{c}.{f}
+var reArtworkSize = regexp.MustCompile(`/(?:\d+x\d+[a-z]*|\{w\}x\{h\}\{c\})\.(?:[a-z]+|\{f\})$`)
+
+// artworkURL returns an artwork URL resized to size x size
+// pixels. Apple renders the image at whatever size the URL
+// asks for.
+func artworkURL(u string, size int) (string, error) {
+
+	loc := reArtworkSize.FindStringIndex(u)
+	if loc == nil {
+		return "", fmt.Errorf("unrecognised artwork URL %q", u)
+	}
+
+	s := strconv.Itoa(size)
+
+	return u[:loc[0]] + "/" + s + "x" + s + "bb.jpg", nil
+}
+
+// DownloadArtwork writes the artwork of the show with the given
+// iTunes ID to w, at size x size pixels. It finds the artwork
+// with the Lookup API and downloads it using the provided
+// Client. If client is nil, the default HTTP client is used.
+// The requests are cancelled if ctx is done.
+func DownloadArtwork(ctx context.Context, id int64, size int, w io.Writer, client Client) error {
+
+	if size <= 0 {
+		return fmt.Errorf("bad artwork size %d", size)
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	client = contextClient{ctx: ctx, client: client}
+
+	podcasts, err := Lookup([]int64{id}, client)
+	if err != nil {
+		return err
+	}
+
+	if len(podcasts) == 0 || podcasts[0].Artwork == "" {
+		return ErrNoArtwork
+	}
+
+	u, err := artworkURL(podcasts[0].Artwork, size)
+	if err != nil {
+		return err
+	}
+
+	resp, err := fetch(client, u)
+	if err != nil {
+		return &URLError{URL: u, Err: err}
+	}
+	defer closeBody(resp.Body)
+
+	ctype := resp.Header.Get("Content-Type")
+	media, _, err := mime.ParseMediaType(ctype)
+	if err != nil {
+		return &URLError{URL: u, Err: &MalformedContentTypeError{ContentType: ctype, Err: err}}
+	}
+	if !strings.HasPrefix(media, "image/") {
+		return &URLError{URL: u, Err: &UnexpectedContentTypeError{ContentType: ctype, MediaType: media, URL: u}}
+	}
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return &URLError{URL: u, Err: err}
+	}
+
+	return nil
+}
+
+// A contextClient sends every request with its context.
+type contextClient struct {
+	ctx    context.Context
+	client Client
+}
+
+func (c contextClient) Do(req *http.Request) (*http.Response, error) {
+	return c.client.Do(req.WithContext(c.ctx))
+}
@@ -0,0 +1,82 @@
+package itunes_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/deepilla/itunes"
+	"github.com/deepilla/itunes/itunestest"
+)
+
+func TestDownloadArtwork(t *testing.T) {
+
+	const (
+		artwork  = "https://is1-ssl.mzstatic.com/image/thumb/Podcasts/v4/ab/cd/ef/source/600x600bb.jpg"
+		notImage = "https://is1-ssl.mzstatic.com/image/thumb/Podcasts/v4/error/source/600x600bb.jpg"
+	)
+
+	s := itunestest.NewServer(
+		itunestest.Show{ID: 1, Title: "Show 1", Artwork: artwork},
+		itunestest.Show{ID: 2, Title: "Show 2"},
+		itunestest.Show{ID: 3, Title: "Show 3", Artwork: notImage},
+	)
+	defer s.Close()
+
+	var paths []string
+	images := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if strings.Contains(r.URL.Path, "/error/") {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html>Not Found</html>"))
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("JPEG"))
+	}))
+	defer images.Close()
+
+	// Send artwork requests to the image server and the rest
+	// to the fake iTunes server.
+	client := clientFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Host == "is1-ssl.mzstatic.com" {
+			return redirectRequests(images, http.DefaultClient).Do(req)
+		}
+		return s.Client().Do(req)
+	})
+
+	var buf bytes.Buffer
+	if err := itunes.DownloadArtwork(context.Background(), 1, 1400, &buf, client); err != nil {
+		t.Fatalf("unexpected error %s", formatError(err))
+	}
+
+	if buf.String() != "JPEG" {
+		t.Errorf("expected image %q, got %q", "JPEG", buf.String())
+	}
+
+	exp := "//image/thumb/Podcasts/v4/ab/cd/ef/source/1400x1400bb.jpg"
+	if len(paths) != 1 || paths[0] != exp {
+		t.Errorf("expected a request for %s, got %v", exp, paths)
+	}
+
+	if err := itunes.DownloadArtwork(context.Background(), 2, 1400, &buf, client); err != itunes.ErrNoArtwork {
+		t.Errorf("expected error %s, got %s", formatError(itunes.ErrNoArtwork), formatError(err))
+	}
+
+	var e *itunes.UnexpectedContentTypeError
+	err := itunes.DownloadArtwork(context.Background(), 3, 1400, &buf, client)
+	if !errors.As(err, &e) || e.MediaType != "text/html" || !strings.HasSuffix(e.URL, "/error/source/1400x1400bb.jpg") {
+		t.Errorf("expected an UnexpectedContentTypeError for text/html, got %s", formatError(err))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := itunes.DownloadArtwork(ctx, 1, 1400, &buf, client); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected error %s, got %s", formatError(context.Canceled), formatError(err))
+	}
+}
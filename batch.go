@@ -0,0 +1,571 @@
+package itunes
+
+import (
+	crand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/cookiejar"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Result is the outcome of resolving one URL in a Batch.
+// Its JSON encoding is described by SchemaVersion.
+type Result struct {
+	URL  string
+	Feed string
+	Err  error
+
+	// Page is the canonical URL of the iTunes page that the
+	// feed was found on, after any redirects. Show pages are
+	// reduced to their ShowURL, which keeps the storefront in
+	// the path and the language but drops the slug, e.g.
+	// https://itunes.apple.com/us/podcast/id917918570.
+	Page string
+
+	// Duration is the time taken to resolve the URL,
+	// including any retries.
+	Duration time.Duration
+
+	// Attempts is the number of times the URL was resolved.
+	// It is greater than 1 if the URL was retried, and 0 if it
+	// failed the HEAD check.
+	Attempts int
+
+	// Requests is the number of HTTP requests made for the
+	// URL, including HEAD checks, Goto redirects and retries.
+	// Lookup API requests shared by a LookupFirst batch aren't
+	// counted.
+	Requests int
+
+	// Strategy names the technique that found the feed, e.g.
+	// "feed-url attribute", or StrategyLookup if it came from
+	// the Lookup API.
+	Strategy string
+
+	// RequestID identifies the requests made for the URL, and
+	// is sent in the Batch's RequestIDHeader if set. It is
+	// empty if the URL was resolved by a LookupFirst batch.
+	RequestID string
+}
+
+// StrategyLookup is the Strategy of a Result whose feed came
+// from the iTunes Lookup API.
+const StrategyLookup = "Lookup API"
+
+// A RetryPolicy decides whether a Batch retries a URL.
+type RetryPolicy interface {
+	// Retry is called after the given attempt, starting at 1,
+	// fails with err. It returns whether to try again and how
+	// long to wait first.
+	Retry(attempt int, err error) (delay time.Duration, ok bool)
+}
+
+// RetryFunc is an adapter to allow the use of an ordinary
+// function as a RetryPolicy.
+type RetryFunc func(attempt int, err error) (time.Duration, bool)
+
+// Retry calls f(attempt, err).
+func (f RetryFunc) Retry(attempt int, err error) (time.Duration, bool) {
+	return f(attempt, err)
+}
+
+// retryTemporary is the RetryPolicy of a Batch that doesn't
+// set one. It retries temporary failures.
+type retryTemporary struct {
+	retries int
+	delay   time.Duration
+}
+
+func (r retryTemporary) Retry(attempt int, err error) (time.Duration, bool) {
+	return r.delay, attempt <= r.retries && isTemporary(err)
+}
+
+// A Jitter selects how a Batch randomizes its delays.
+type Jitter int
+
+const (
+	// NoJitter waits for the full delay. It is the default.
+	NoJitter Jitter = iota
+
+	// FullJitter waits for a random time between zero and the
+	// delay.
+	FullJitter
+
+	// EqualJitter waits for half the delay plus a random time
+	// up to the other half.
+	EqualJitter
+)
+
+// apply returns delay randomized according to j, using int63n
+// for random numbers.
+func (j Jitter) apply(delay time.Duration, int63n func(n int64) int64) time.Duration {
+
+	if delay <= 0 {
+		return delay
+	}
+
+	if int63n == nil {
+		int63n = rand.Int63n
+	}
+
+	switch j {
+	case FullJitter:
+		return time.Duration(int63n(int64(delay) + 1))
+	case EqualJitter:
+		half := delay / 2
+		return half + time.Duration(int63n(int64(delay-half)+1))
+	}
+
+	return delay
+}
+
+// A Renderer loads a page in a browser, or something like
+// one, and returns its HTML after any scripts have run. Some
+// pages only include their feed after client-side rendering.
+type Renderer interface {
+	Render(url string) (html string, err error)
+}
+
+// A Mode selects how a Batch uses the iTunes Lookup API.
+// Fetching pages finds feeds for shows that the API doesn't
+// know about, but the API is faster and more stable.
+type Mode int
+
+const (
+	// ScrapeOnly fetches each URL's page. It is the default.
+	ScrapeOnly Mode = iota
+
+	// LookupFirst resolves Apple URLs with the Lookup API, up
+	// to 200 IDs per request, then fetches the pages of URLs
+	// that the API can't resolve.
+	LookupFirst
+
+	// ScrapeFirst fetches each URL's page, then resolves Apple
+	// URLs that fail with the Lookup API.
+	ScrapeFirst
+)
+
+// A Batch resolves multiple iTunes URLs. The zero value is
+// ready to use. A Batch is safe for concurrent use by multiple
+// goroutines, as long as its fields aren't changed while it is
+// in use and its Client, Renderer and Clock are also safe. All
+// of its settings are in its fields, including Options, so
+// Batches with different settings can run at the same time.
+type Batch struct {
+	// Client executes the HTTP requests. If nil, the default
+	// HTTP client is used.
+	Client Client
+
+	// Options configure how pages are read and feeds found.
+	Options Options
+
+	// Retries is the number of times to retry a URL after a
+	// temporary failure, such as a network error or a server
+	// error response.
+	Retries int
+
+	// RetryDelay is the time to wait before each retry.
+	RetryDelay time.Duration
+
+	// RetryPolicy, if set, decides which failures are retried
+	// and how long to wait first. It replaces Retries and
+	// RetryDelay.
+	RetryPolicy RetryPolicy
+
+	// Jitter randomizes the wait before each retry and each
+	// attempt with an alternate User Agent, so that workers
+	// that fail together don't retry together.
+	Jitter Jitter
+
+	// Rand, if set, returns a random number in [0, n) for
+	// Jitter. It must be safe for concurrent use if the Batch
+	// is used concurrently. If nil, math/rand is used.
+	Rand func(n int64) int64
+
+	// HeadCheck enables sending a HEAD request for each URL
+	// before fetching it. URLs whose Content Type or size show
+	// that they can't lead to a feed, e.g. images, fail without
+	// being fetched. Use it for URLs from untrusted sources.
+	HeadCheck bool
+
+	// BlockedUserAgents, if set, are alternate User Agents to
+	// try, in order, for a URL that fails with ErrBlocked. Each
+	// attempt waits BlockedDelay and starts with fresh cookies.
+	// If Client is an *http.Client with a Jar, the attempts use
+	// a copy of it without the Jar. Other Clients that keep
+	// their own cookies still send them.
+	BlockedUserAgents []string
+
+	// BlockedDelay is the time to wait before each attempt with
+	// an alternate User Agent.
+	BlockedDelay time.Duration
+
+	// Renderer, if set, renders pages that have no feed when
+	// fetched, and the rendered HTML is searched instead.
+	Renderer Renderer
+
+	// Mode selects whether the iTunes Lookup API is used to
+	// resolve Apple URLs, and whether it is tried before or
+	// after fetching their pages.
+	Mode Mode
+
+	// RequestIDHeader, if set, is the name of a header, e.g.
+	// "X-Request-Id", that carries each Result's RequestID in
+	// the requests made for its URL.
+	RequestIDHeader string
+
+	// NewRequestID, if set, returns the RequestID for a URL.
+	// The default is a random 16-character hex string.
+	NewRequestID func(url string) string
+
+	// Clock, if set, provides the time for Durations and
+	// waits out the delays between attempts. If nil,
+	// SystemClock is used.
+	Clock Clock
+
+	// Progress, if set, is called after each URL is resolved
+	// with the number of URLs done so far, the total number
+	// of URLs, and the most recent Result.
+	Progress func(done, total int, last Result)
+}
+
+// ToRSS returns the underlying RSS feeds from a list of iTunes
+// URLs. The results are in the same order as the URLs. Each
+// URL is resolved independently, so a failure is reported in
+// its Result and does not stop the batch.
+//
+// URLs that refer to the same iTunes ID, e.g. different
+// spellings of a show's URL, are only fetched once and share
+// a Result.
+func (b *Batch) ToRSS(urls []string) []Result {
+
+	results := make([]Result, len(urls))
+
+	// Maps a canonical key to the index of its first result.
+	seen := map[string]int{}
+
+	var found map[string]Podcast
+	if b.Mode == LookupFirst {
+		found = b.lookup(urls, b.Client)
+	}
+
+	for i, u := range urls {
+
+		key := batchKey(u)
+		if j, ok := seen[key]; ok {
+			results[i] = results[j]
+			results[i].URL = u
+		} else if p, ok := found[key]; ok {
+			seen[key] = i
+			results[i] = Result{
+				URL:      u,
+				Feed:     p.Feed,
+				Page:     canonicalURL(p.URL),
+				Attempts: 1,
+				Strategy: StrategyLookup,
+			}
+		} else {
+			seen[key] = i
+			results[i] = b.resolve(u)
+		}
+
+		if b.Progress != nil {
+			b.Progress(i+1, len(urls), results[i])
+		}
+	}
+
+	return results
+}
+
+func (b *Batch) resolve(u string) Result {
+
+	res := Result{
+		URL:       u,
+		RequestID: b.requestID(u),
+	}
+
+	clock := clockOrDefault(b.Clock)
+	start := clock.Now()
+
+	client := &requestCounter{client: b.requestClient(b.Client, res.RequestID)}
+
+	if b.HeadCheck {
+		if err := b.Options.headCheck(client, u); err != nil {
+			res.Err = &URLError{URL: u, Err: err, RequestID: res.RequestID}
+			res.Requests = client.n
+			res.Duration = clock.Now().Sub(start)
+			return res
+		}
+	}
+
+	policy := b.RetryPolicy
+	if policy == nil {
+		policy = retryTemporary{retries: b.Retries, delay: b.RetryDelay}
+	}
+
+	for {
+		res.Attempts++
+		res.Feed, res.Page, res.Strategy, res.Err = b.Options.toRSS(u, client)
+
+		if res.Err == nil {
+			break
+		}
+
+		delay, ok := policy.Retry(res.Attempts, res.Err)
+		if !ok {
+			break
+		}
+
+		clock.Sleep(b.Jitter.apply(delay, b.Rand))
+	}
+
+	for _, ua := range b.BlockedUserAgents {
+
+		if !errors.Is(res.Err, ErrBlocked) {
+			break
+		}
+
+		clock.Sleep(b.Jitter.apply(b.BlockedDelay, b.Rand))
+
+		agent := &requestCounter{client: b.requestClient(newAgentClient(b.Client, ua), res.RequestID)}
+
+		res.Attempts++
+		res.Feed, res.Page, res.Strategy, res.Err = b.Options.toRSS(u, agent)
+		client.n += agent.n
+	}
+
+	if b.Renderer != nil && isPageNoFeed(res.Err) {
+		res.Attempts++
+		res.Feed, res.Page, res.Strategy, res.Err = b.render(res.Err)
+	}
+
+	if res.Err != nil && b.Mode == ScrapeFirst {
+		if id, ok := podcastID(u); ok {
+			res.Attempts++
+			if p, ok := b.lookup([]string{u}, client)["id:"+id]; ok {
+				res.Feed, res.Page, res.Strategy, res.Err = p.Feed, canonicalURL(p.URL), StrategyLookup, nil
+			}
+		}
+	}
+
+	var e *URLError
+	if errors.As(res.Err, &e) {
+		e.RequestID = res.RequestID
+	}
+
+	res.Requests = client.n
+	res.Duration = clock.Now().Sub(start)
+
+	return res
+}
+
+// isPageNoFeed reports whether err is a NoFeedError from an
+// HTML page, as opposed to a plist, that might have a feed once
+// rendered. Pages of a known Kind, like iTunes U courses, don't.
+func isPageNoFeed(err error) bool {
+
+	var e *NoFeedError
+	if !errors.As(err, &e) || e.Kind != nil {
+		return false
+	}
+
+	for _, se := range e.Errors {
+		if se.Strategy == gotoStrategy {
+			return false
+		}
+	}
+
+	return true
+}
+
+// render renders the page that failed with err and searches
+// it for a feed.
+func (b *Batch) render(err error) (feed, page, strategy string, _ error) {
+
+	var e *URLError
+	if !errors.As(err, &e) {
+		return "", "", "", err
+	}
+
+	html, rerr := b.Renderer.Render(e.URL)
+	if rerr != nil {
+		return "", "", "", &URLError{URL: e.URL, Hop: e.Hop, Err: fmt.Errorf("render error: %w", rerr)}
+	}
+
+	feed, strategy, perr := b.Options.parseHTML(strings.NewReader(html))
+	if perr != nil {
+		return "", "", "", &URLError{URL: e.URL, Hop: e.Hop, Err: perr}
+	}
+
+	return feed, canonicalURL(e.URL), strategy, nil
+}
+
+// requestID returns the RequestID for u.
+func (b *Batch) requestID(u string) string {
+
+	if b.NewRequestID != nil {
+		return b.NewRequestID(u)
+	}
+
+	var buf [8]byte
+	crand.Read(buf[:])
+	return hex.EncodeToString(buf[:])
+}
+
+// requestClient returns client, or the default client if it's
+// nil, set up to send the Batch's request ID header.
+func (b *Batch) requestClient(client Client, requestID string) Client {
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	if b.RequestIDHeader != "" {
+		client = &headerClient{
+			client: client,
+			name:   b.RequestIDHeader,
+			value:  requestID,
+		}
+	}
+
+	return client
+}
+
+// A headerClient sets a header on each request.
+type headerClient struct {
+	client      Client
+	name, value string
+}
+
+func (c *headerClient) Do(req *http.Request) (*http.Response, error) {
+	req.Header.Set(c.name, c.value)
+	return c.client.Do(req)
+}
+
+// A requestCounter counts the requests sent through a Client.
+type requestCounter struct {
+	client Client
+	n      int
+}
+
+func (c *requestCounter) Do(req *http.Request) (*http.Response, error) {
+	c.n++
+	return c.client.Do(req)
+}
+
+// An agentClient sends requests with its own User Agent and
+// cookies.
+type agentClient struct {
+	client    Client
+	userAgent string
+	jar       http.CookieJar
+}
+
+func newAgentClient(client Client, userAgent string) *agentClient {
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	// Drop the Jar of an *http.Client, or it would send the
+	// cookies of the blocked requests.
+	if c, ok := client.(*http.Client); ok && c.Jar != nil {
+		noJar := *c
+		noJar.Jar = nil
+		client = &noJar
+	}
+
+	// cookiejar.New only fails if given a bad PublicSuffixList.
+	jar, _ := cookiejar.New(nil)
+
+	return &agentClient{
+		client:    client,
+		userAgent: userAgent,
+		jar:       jar,
+	}
+}
+
+func (c *agentClient) Do(req *http.Request) (*http.Response, error) {
+
+	req.Header.Set("User-Agent", c.userAgent)
+	for _, cookie := range c.jar.Cookies(req.URL) {
+		req.AddCookie(cookie)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	c.jar.SetCookies(req.URL, resp.Cookies())
+
+	return resp, nil
+}
+
+// lookup returns the shows with feeds that the Lookup API finds
+// for the URLs, keyed by batchKey, sending the requests through
+// client. Errors are ignored, leaving the URLs unresolved.
+func (b *Batch) lookup(urls []string, client Client) map[string]Podcast {
+
+	var ids []int64
+	queued := map[int64]bool{}
+
+	for _, u := range urls {
+		s, ok := podcastID(u)
+		if !ok {
+			continue
+		}
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || queued[id] {
+			continue
+		}
+		queued[id] = true
+		ids = append(ids, id)
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	podcasts, err := Lookup(ids, client)
+	if err != nil {
+		return nil
+	}
+
+	// Shows with invalid feeds are left for their pages to
+	// resolve or reject.
+	found := map[string]Podcast{}
+	for _, p := range podcasts {
+		if feed, err := checkFeedURL(p.Feed); err == nil {
+			p.Feed = feed
+			found["id:"+strconv.FormatInt(p.ID, 10)] = p
+		}
+	}
+
+	return found
+}
+
+// batchKey returns a key that is the same for any two URLs
+// that refer to the same show.
+func batchKey(u string) string {
+	if id, ok := podcastID(u); ok {
+		return "id:" + id
+	}
+	return "url:" + u
+}
+
+// podcastID returns the iTunes ID from an Apple URL.
+func podcastID(s string) (string, bool) {
+	u, err := ParseURL(s)
+	return u.ID, err == nil
+}
+
+func isAppleHost(host string) bool {
+	host = strings.ToLower(host)
+	return host == "apple.com" || strings.HasSuffix(host, ".apple.com")
+}
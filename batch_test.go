@@ -0,0 +1,1056 @@
+package itunes_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/deepilla/itunes"
+	"github.com/deepilla/itunes/itunestest"
+)
+
+func TestBatch(t *testing.T) {
+
+	const (
+		serial = "http://feeds.serialpodcast.org/serialpodcast"
+		stown  = "http://feeds.stownpodcast.org/stownpodcast"
+	)
+
+	s := itunestest.NewServer(
+		itunestest.Show{ID: 917918570, Feed: serial},
+		itunestest.Show{ID: 1212558767, Feed: stown},
+	)
+	defer s.Close()
+
+	client := &countingClient{Client: s.Client()}
+	b := &itunes.Batch{Client: client}
+
+	urls := []string{
+		"https://itunes.apple.com/us/podcast/serial/id917918570?mt=2",
+		"https://itunes.apple.com/us/podcast/s-town/id1212558767",
+		"https://podcasts.apple.com/gb/podcast/serial/id917918570",
+		"https://itunes.apple.com/WebObjects/DZR.woa/wa/viewPodcast?id=917918570",
+		"https://itunes.apple.com/us/podcast/id404",
+		"https://itunes.apple.com/us/podcast/id404",
+	}
+
+	exp := []struct {
+		Feed  string
+		Error bool
+	}{
+		{Feed: serial},
+		{Feed: stown},
+		{Feed: serial},
+		{Feed: serial},
+		{Error: true},
+		{Error: true},
+	}
+
+	results := b.ToRSS(urls)
+
+	if len(results) != len(urls) {
+		t.Fatalf("expected %d results, got %d", len(urls), len(results))
+	}
+
+	for i, res := range results {
+
+		if res.URL != urls[i] {
+			t.Errorf("result %d: expected URL %q, got %q", i, urls[i], res.URL)
+		}
+
+		if res.Feed != exp[i].Feed {
+			t.Errorf("result %d: expected feed %q, got %q", i, exp[i].Feed, res.Feed)
+		}
+
+		if got := res.Err != nil; got != exp[i].Error {
+			t.Errorf("result %d: expected error %t, got %s", i, exp[i].Error, formatError(res.Err))
+		}
+	}
+
+	// One request each for Serial, S-Town and the missing show.
+	if got := client.Count(); got != 3 {
+		t.Errorf("expected 3 requests, got %d", got)
+	}
+}
+
+func TestBatchLookup(t *testing.T) {
+
+	const (
+		serial = "http://feeds.serialpodcast.org/serialpodcast"
+		stown  = "http://feeds.stownpodcast.org/stownpodcast"
+	)
+
+	s := itunestest.NewServer(
+		itunestest.Show{ID: 917918570, Feed: serial},
+		itunestest.Show{ID: 1212558767, Feed: stown, Response: itunestest.ResponseItemNotAvailable},
+	)
+	defer s.Close()
+
+	client := &countingClient{Client: s.Client()}
+	b := &itunes.Batch{
+		Client: client,
+		Mode:   itunes.LookupFirst,
+	}
+
+	urls := []string{
+		"https://itunes.apple.com/us/podcast/serial/id917918570?mt=2",
+		"https://itunes.apple.com/us/podcast/s-town/id1212558767",
+		"https://podcasts.apple.com/gb/podcast/serial/id917918570",
+		"https://example.com/podcast",
+	}
+
+	results := b.ToRSS(urls)
+
+	if results[0].Feed != serial || results[2].Feed != serial {
+		t.Errorf("expected feed %q for Serial, got %q and %q", serial, results[0].Feed, results[2].Feed)
+	}
+
+	// S-Town isn't returned by the Lookup API, so its page is
+	// fetched instead.
+	if results[1].Err == nil {
+		t.Error("expected an error for S-Town")
+	}
+
+	if results[3].Err == nil {
+		t.Error("expected an error for a non-Apple URL")
+	}
+
+	// One lookup, then one request each for S-Town and the
+	// non-Apple URL.
+	if got := client.Count(); got != 3 {
+		t.Errorf("expected 3 requests, got %d", got)
+	}
+}
+
+func TestBatchLookupInvalidFeed(t *testing.T) {
+
+	s := itunestest.NewServer(itunestest.Show{ID: 917918570, Feed: "feeds/serialpodcast"})
+	defer s.Close()
+
+	b := &itunes.Batch{
+		Client: s.Client(),
+		Mode:   itunes.LookupFirst,
+	}
+
+	res := b.ToRSS([]string{"https://itunes.apple.com/us/podcast/id917918570"})[0]
+
+	if res.Feed != "" || !errors.Is(res.Err, itunes.ErrInvalidFeedURL) {
+		t.Errorf("expected an error matching ErrInvalidFeedURL, got feed %q, error %s", res.Feed, formatError(res.Err))
+	}
+
+	if res.Strategy == itunes.StrategyLookup {
+		t.Error("expected the invalid feed from the Lookup API to be ignored")
+	}
+}
+
+func TestBatchScrapeFirst(t *testing.T) {
+
+	const (
+		serial = "http://feeds.serialpodcast.org/serialpodcast"
+		stown  = "http://feeds.stownpodcast.org/stownpodcast"
+	)
+
+	s := itunestest.NewServer(
+		itunestest.Show{ID: 917918570, Feed: serial},
+		itunestest.Show{ID: 1212558767, Feed: stown, Response: itunestest.ResponseNoFeed},
+		itunestest.Show{ID: 1, Response: itunestest.ResponseNoFeed},
+	)
+	defer s.Close()
+
+	// Records the request ID of each Lookup API request.
+	var lookups []string
+	client := &countingClient{Client: clientFunc(func(req *http.Request) (*http.Response, error) {
+		if strings.HasSuffix(req.URL.Path, "/lookup") {
+			lookups = append(lookups, req.Header.Get("X-Request-Id"))
+		}
+		return s.Client().Do(req)
+	})}
+
+	b := &itunes.Batch{
+		Client:          client,
+		Mode:            itunes.ScrapeFirst,
+		RequestIDHeader: "X-Request-Id",
+	}
+
+	results := b.ToRSS([]string{
+		"https://itunes.apple.com/us/podcast/serial/id917918570",
+		"https://itunes.apple.com/us/podcast/s-town/id1212558767",
+		"https://itunes.apple.com/us/podcast/id1",
+	})
+
+	// S-Town's page has no feed, so the Lookup API is used.
+	for i, feed := range []string{serial, stown} {
+		if results[i].Err != nil || results[i].Feed != feed {
+			t.Errorf("result %d: expected feed %q, got %q (error %s)", i, feed, results[i].Feed, formatError(results[i].Err))
+		}
+	}
+
+	if results[1].Attempts != 2 || results[1].Requests != 2 {
+		t.Errorf("expected 2 attempts and 2 requests for S-Town, got %d and %d", results[1].Attempts, results[1].Requests)
+	}
+
+	// Three pages, then two lookups, sent with the request IDs
+	// of their URLs.
+	if got := client.Count(); got != 5 {
+		t.Errorf("expected 5 requests, got %d", got)
+	}
+
+	if exp := []string{results[1].RequestID, results[2].RequestID}; fmt.Sprint(lookups) != fmt.Sprint(exp) {
+		t.Errorf("expected lookups with request IDs %v, got %v", exp, lookups)
+	}
+
+	// Neither the page nor the API has a feed for show 1.
+	var e *itunes.URLError
+	if err := results[2].Err; !errors.As(err, &e) || e.RequestID != results[2].RequestID || !strings.Contains(err.Error(), results[2].RequestID) {
+		t.Errorf("expected a URLError with request ID %q, got %s", results[2].RequestID, formatError(err))
+	}
+}
+
+func TestBatchBlocked(t *testing.T) {
+
+	const feed = "http://feeds.serialpodcast.org/serialpodcast"
+
+	s := itunestest.NewServer(itunestest.Show{ID: 917918570, Feed: feed})
+	defer s.Close()
+
+	var agents []string
+	client := clientFunc(func(req *http.Request) (*http.Response, error) {
+
+		ua := req.Header.Get("User-Agent")
+		agents = append(agents, ua)
+
+		// Only the second alternate User Agent gets through,
+		// and only without the first one's cookie.
+		if _, err := req.Cookie("session"); ua != "agent-2" || err == nil {
+			return &http.Response{
+				StatusCode: http.StatusForbidden,
+				Status:     "403 Forbidden",
+				Header:     http.Header{"Set-Cookie": {"session=1"}},
+				Body:       http.NoBody,
+				Request:    req,
+			}, nil
+		}
+
+		req.Header.Set("User-Agent", itunestest.UserAgent)
+		return s.Client().Do(req)
+	})
+
+	b := &itunes.Batch{
+		Client:            client,
+		BlockedUserAgents: []string{"agent-1", "agent-2", "agent-3"},
+	}
+
+	res := b.ToRSS([]string{"https://itunes.apple.com/us/podcast/serial/id917918570"})[0]
+
+	if res.Err != nil {
+		t.Fatalf("unexpected error %s", formatError(res.Err))
+	}
+
+	if res.Feed != feed {
+		t.Errorf("expected feed %q, got %q", feed, res.Feed)
+	}
+
+	if got, exp := strings.Join(agents, ","), itunestest.UserAgent+",agent-1,agent-2"; got != exp {
+		t.Errorf("expected User Agents %s, got %s", exp, got)
+	}
+
+	if res.Attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", res.Attempts)
+	}
+}
+
+func TestBatchBlockedJar(t *testing.T) {
+
+	const feed = "http://feeds.serialpodcast.org/serialpodcast"
+
+	var agents []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		ua := r.Header.Get("User-Agent")
+		agents = append(agents, ua)
+
+		// Only the alternate User Agent gets through, and only
+		// without the blocked request's cookie.
+		if _, err := r.Cookie("session"); ua != "agent-1" || err == nil {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "1"})
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		w.Write(itunestest.Page("Serial", feed))
+	}))
+	defer ts.Close()
+
+	base, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &itunes.Batch{
+		Client: &http.Client{
+			Jar: jar,
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				req = req.Clone(req.Context())
+				req.URL.Scheme = base.Scheme
+				req.URL.Host = base.Host
+				return http.DefaultTransport.RoundTrip(req)
+			}),
+		},
+		BlockedUserAgents: []string{"agent-1"},
+	}
+
+	res := b.ToRSS([]string{"https://itunes.apple.com/us/podcast/serial/id917918570"})[0]
+
+	if res.Err != nil {
+		t.Fatalf("unexpected error %s (User Agents %q)", formatError(res.Err), agents)
+	}
+
+	if res.Feed != feed {
+		t.Errorf("expected feed %q, got %q", feed, res.Feed)
+	}
+
+	if res.Attempts != 2 || res.Requests != 2 {
+		t.Errorf("expected 2 attempts and 2 requests, got %d and %d", res.Attempts, res.Requests)
+	}
+}
+
+func TestBatchErrors(t *testing.T) {
+
+	b := &itunes.Batch{
+		Client: clientFunc(func(*http.Request) (*http.Response, error) {
+			return nil, errors.New("network down")
+		}),
+	}
+
+	results := b.ToRSS([]string{"https://itunes.apple.com/us/podcast/id1", "https://itunes.apple.com/us/podcast/id2"})
+
+	for i, res := range results {
+		if res.Err == nil {
+			t.Errorf("result %d: expected an error", i)
+		}
+	}
+}
+
+func TestBatchRetries(t *testing.T) {
+
+	const feed = "http://feeds.serialpodcast.org/serialpodcast"
+
+	s := itunestest.NewServer(
+		itunestest.Show{ID: 917918570, Feed: feed},
+		itunestest.Show{ID: 1212558767, Status: http.StatusServiceUnavailable},
+	)
+	defer s.Close()
+
+	data := []struct {
+		URL      string
+		Failures int
+		Retries  int
+		Feed     string
+		Attempts int
+	}{
+		{
+			URL:      "https://itunes.apple.com/us/podcast/id917918570",
+			Failures: 0,
+			Retries:  2,
+			Feed:     feed,
+			Attempts: 1,
+		},
+		{
+			URL:      "https://itunes.apple.com/us/podcast/id917918570",
+			Failures: 2,
+			Retries:  2,
+			Feed:     feed,
+			Attempts: 3,
+		},
+		{
+			URL:      "https://itunes.apple.com/us/podcast/id917918570",
+			Failures: 3,
+			Retries:  2,
+			Attempts: 3,
+		},
+		{
+			// Server errors are retried.
+			URL:      "https://itunes.apple.com/us/podcast/id1212558767",
+			Retries:  2,
+			Attempts: 3,
+		},
+		{
+			// Not Found is not.
+			URL:      "https://itunes.apple.com/us/podcast/id404",
+			Retries:  2,
+			Attempts: 1,
+		},
+	}
+
+	for _, test := range data {
+
+		failures := test.Failures
+		b := &itunes.Batch{
+			Client: clientFunc(func(req *http.Request) (*http.Response, error) {
+				if failures > 0 {
+					failures--
+					return nil, errors.New("connection reset")
+				}
+				return s.Client().Do(req)
+			}),
+			Retries: test.Retries,
+		}
+
+		res := b.ToRSS([]string{test.URL})[0]
+
+		if res.Feed != test.Feed {
+			t.Errorf("%s (%d failures): expected feed %q, got %q", test.URL, test.Failures, test.Feed, res.Feed)
+		}
+
+		if got := res.Err != nil; got != (test.Feed == "") {
+			t.Errorf("%s (%d failures): unexpected error %s", test.URL, test.Failures, formatError(res.Err))
+		}
+
+		if res.Attempts != test.Attempts {
+			t.Errorf("%s (%d failures): expected %d attempts, got %d", test.URL, test.Failures, test.Attempts, res.Attempts)
+		}
+
+		if res.Duration <= 0 {
+			t.Errorf("%s (%d failures): expected a positive duration, got %s", test.URL, test.Failures, res.Duration)
+		}
+	}
+}
+
+func TestBatchRetryPolicy(t *testing.T) {
+
+	const feed = "http://feeds.serialpodcast.org/serialpodcast"
+
+	s := itunestest.NewServer(itunestest.Show{ID: 917918570, Feed: feed})
+	defer s.Close()
+
+	// Retry a 403 once and anything else never.
+	var calls []int
+	policy := itunes.RetryFunc(func(attempt int, err error) (time.Duration, bool) {
+		code := itunes.StatusCode(err)
+		calls = append(calls, code)
+		return 0, code == http.StatusForbidden && attempt == 1
+	})
+
+	data := []struct {
+		Status   int
+		Failures int
+		Feed     string
+		Attempts int
+	}{
+		{Status: http.StatusForbidden, Failures: 1, Feed: feed, Attempts: 2},
+		{Status: http.StatusForbidden, Failures: 2, Attempts: 2},
+		{Status: http.StatusGone, Failures: 1, Attempts: 1},
+		// Server errors are only retried by the default policy.
+		{Status: http.StatusServiceUnavailable, Failures: 1, Attempts: 1},
+	}
+
+	for _, test := range data {
+
+		failures := test.Failures
+		b := &itunes.Batch{
+			Client: clientFunc(func(req *http.Request) (*http.Response, error) {
+				if failures > 0 {
+					failures--
+					return &http.Response{
+						StatusCode: test.Status,
+						Status:     http.StatusText(test.Status),
+						Body:       http.NoBody,
+						Request:    req,
+					}, nil
+				}
+				return s.Client().Do(req)
+			}),
+			Retries:     5,
+			RetryPolicy: policy,
+		}
+
+		calls = nil
+		res := b.ToRSS([]string{"https://itunes.apple.com/us/podcast/id917918570"})[0]
+
+		if res.Feed != test.Feed {
+			t.Errorf("%d (%d failures): expected feed %q, got %q (error %s)", test.Status, test.Failures, test.Feed, res.Feed, formatError(res.Err))
+		}
+
+		if res.Attempts != test.Attempts {
+			t.Errorf("%d (%d failures): expected %d attempts, got %d", test.Status, test.Failures, test.Attempts, res.Attempts)
+		}
+
+		if len(calls) == 0 || calls[0] != test.Status {
+			t.Errorf("%d (%d failures): expected the policy to see status %d, got %v", test.Status, test.Failures, test.Status, calls)
+		}
+	}
+}
+
+func TestBatchJitter(t *testing.T) {
+
+	const (
+		delay   = 40 * time.Second
+		retries = 20
+	)
+
+	data := []struct {
+		Jitter itunes.Jitter
+		Min    time.Duration
+		Max    time.Duration
+		Varies bool
+	}{
+		{itunes.NoJitter, delay, delay, false},
+		{itunes.EqualJitter, delay / 2, delay, true},
+		{itunes.FullJitter, 0, delay, true},
+	}
+
+	for _, test := range data {
+
+		clock := &fakeClock{}
+
+		b := &itunes.Batch{
+			Client: clientFunc(func(*http.Request) (*http.Response, error) {
+				return nil, errors.New("connection reset")
+			}),
+			Retries:    retries,
+			RetryDelay: delay,
+			Jitter:     test.Jitter,
+			Clock:      clock,
+			Rand:       rand.New(rand.NewSource(1)).Int63n,
+		}
+
+		res := b.ToRSS([]string{"https://itunes.apple.com/us/podcast/id917918570"})[0]
+
+		if res.Attempts != retries+1 || len(clock.sleeps) != retries {
+			t.Errorf("jitter %d: expected %d attempts and %d delays, got %d and %d", test.Jitter, retries+1, retries, res.Attempts, len(clock.sleeps))
+		}
+
+		distinct := map[time.Duration]bool{}
+		for _, d := range clock.sleeps {
+			distinct[d] = true
+			if d < test.Min || d > test.Max {
+				t.Errorf("jitter %d: expected delays between %s and %s, got %s", test.Jitter, test.Min, test.Max, d)
+			}
+		}
+
+		if varies := len(distinct) > 1; varies != test.Varies {
+			t.Errorf("jitter %d: expected delays that vary %t, got %v", test.Jitter, test.Varies, clock.sleeps)
+		}
+	}
+}
+
+func TestBatchRequestID(t *testing.T) {
+
+	s := itunestest.NewServer(
+		itunestest.Show{ID: 1, Feed: "https://example.com/feeds/1", Hops: 1},
+		itunestest.Show{ID: 2, Feed: "https://example.com/feeds/2"},
+	)
+	defer s.Close()
+
+	var mu sync.Mutex
+	ids := map[string][]string{}
+
+	client := clientFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		ids[req.URL.Path] = append(ids[req.URL.Path], req.Header.Get("X-Request-Id"))
+		mu.Unlock()
+		return s.Client().Do(req)
+	})
+
+	b := &itunes.Batch{
+		Client:          client,
+		RequestIDHeader: "X-Request-Id",
+	}
+
+	results := b.ToRSS([]string{
+		"https://itunes.apple.com/us/podcast/id1",
+		"https://itunes.apple.com/us/podcast/id2",
+	})
+
+	if results[0].RequestID == "" || results[0].RequestID == results[1].RequestID {
+		t.Fatalf("expected distinct request IDs, got %q and %q", results[0].RequestID, results[1].RequestID)
+	}
+
+	for i, path := range []string{"/us/podcast/id1", "/us/podcast/id2"} {
+
+		if len(ids[path]) == 0 {
+			t.Errorf("%s: expected requests", path)
+		}
+
+		// Show 1 is fetched twice, once for its Goto plist.
+		for _, id := range ids[path] {
+			if id != results[i].RequestID {
+				t.Errorf("%s: expected request ID %q, got %q", path, results[i].RequestID, id)
+			}
+		}
+	}
+
+	b.NewRequestID = func(u string) string {
+		return "id-" + u[len(u)-1:]
+	}
+
+	if got := b.ToRSS([]string{"https://itunes.apple.com/us/podcast/id2"})[0].RequestID; got != "id-2" {
+		t.Errorf("expected request ID %q, got %q", "id-2", got)
+	}
+}
+
+func TestBatchClock(t *testing.T) {
+
+	clock := &fakeClock{now: time.Date(2017, 3, 28, 10, 0, 0, 0, time.UTC)}
+
+	b := &itunes.Batch{
+		Client: clientFunc(func(*http.Request) (*http.Response, error) {
+			return nil, errors.New("connection reset")
+		}),
+		Retries:    2,
+		RetryDelay: time.Hour,
+		Clock:      clock,
+	}
+
+	res := b.ToRSS([]string{"https://itunes.apple.com/us/podcast/id917918570"})[0]
+
+	if res.Attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", res.Attempts)
+	}
+
+	if got := fmt.Sprint(clock.sleeps); got != "[1h0m0s 1h0m0s]" {
+		t.Errorf("expected two sleeps of an hour, got %s", got)
+	}
+
+	if res.Duration != 2*time.Hour {
+		t.Errorf("expected a duration of 2h, got %s", res.Duration)
+	}
+}
+
+// A fakeClock is an itunes.Clock whose Sleep advances the time
+// without waiting.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sleeps = append(c.sleeps, d)
+	c.now = c.now.Add(d)
+}
+
+func TestBatchProgress(t *testing.T) {
+
+	s := itunestest.NewServer(itunestest.Show{ID: 917918570, Feed: "http://feeds.serialpodcast.org/serialpodcast"})
+	defer s.Close()
+
+	urls := []string{
+		"https://itunes.apple.com/us/podcast/id917918570",
+		"https://itunes.apple.com/us/podcast/id404",
+		"https://itunes.apple.com/gb/podcast/serial/id917918570",
+	}
+
+	var calls []int
+	b := &itunes.Batch{
+		Client: s.Client(),
+		Progress: func(done, total int, last itunes.Result) {
+			if total != len(urls) {
+				t.Errorf("expected total %d, got %d", len(urls), total)
+			}
+			if last.URL != urls[done-1] {
+				t.Errorf("progress %d: expected last URL %q, got %q", done, urls[done-1], last.URL)
+			}
+			calls = append(calls, done)
+		},
+	}
+
+	b.ToRSS(urls)
+
+	if len(calls) != len(urls) {
+		t.Fatalf("expected %d progress calls, got %d", len(urls), len(calls))
+	}
+
+	for i, done := range calls {
+		if done != i+1 {
+			t.Errorf("call %d: expected done %d, got %d", i, i+1, done)
+		}
+	}
+}
+
+type countingClient struct {
+	Client itunes.Client
+
+	mu sync.Mutex
+	n  int
+}
+
+func (c *countingClient) Do(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	c.n++
+	c.mu.Unlock()
+	return c.Client.Do(req)
+}
+
+func (c *countingClient) Count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.n
+}
+
+func TestBatchConnectionReuse(t *testing.T) {
+
+	// Bodies that are too big for the response to be read to
+	// EOF by accident.
+	padding := strings.Repeat(" ", 32<<10)
+
+	mux := http.NewServeMux()
+	ts := httptest.NewUnstartedServer(mux)
+
+	var mu sync.Mutex
+	conns := 0
+	ts.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mu.Lock()
+			conns++
+			mu.Unlock()
+		}
+	}
+
+	ts.Start()
+	defer ts.Close()
+
+	mux.HandleFunc("/plist", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write(itunestest.GotoPlist(ts.URL + "/page"))
+		w.Write([]byte(padding))
+	})
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write(itunestest.Page("", "http://example.com/feed"))
+	})
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, padding, http.StatusNotFound)
+	})
+
+	client := &http.Client{
+		Transport: &http.Transport{},
+	}
+
+	b := &itunes.Batch{Client: client}
+	results := b.ToRSS([]string{
+		ts.URL + "/plist",
+		ts.URL + "/missing",
+		ts.URL + "/page",
+	})
+
+	if results[0].Err != nil || results[2].Err != nil {
+		t.Fatalf("unexpected errors %s, %s", formatError(results[0].Err), formatError(results[2].Err))
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if conns != 1 {
+		t.Errorf("expected 1 connection, got %d", conns)
+	}
+}
+
+func TestBatchHeadCheck(t *testing.T) {
+
+	var methods []string
+
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method+" /image")
+		w.Header().Set("Content-Type", "image/jpeg")
+	})
+	mux.HandleFunc("/huge", func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method+" /huge")
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Length", "1000000000")
+	})
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method+" /page")
+		w.Header().Set("Content-Type", "text/html")
+		w.Write(itunestest.Page("", "http://example.com/feed"))
+	})
+	mux.HandleFunc("/no-head", func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method+" /no-head")
+		if r.Method == "HEAD" {
+			http.Error(w, "", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write(itunestest.Page("", "http://example.com/feed"))
+	})
+
+	b := &itunes.Batch{
+		HeadCheck: true,
+	}
+
+	results := b.ToRSS([]string{
+		ts.URL + "/image",
+		ts.URL + "/huge",
+		ts.URL + "/page",
+		ts.URL + "/no-head",
+	})
+
+	for i, fails := range []bool{true, true, false, false} {
+		if got := results[i].Err != nil; got != fails {
+			t.Errorf("result %d: expected error %t, got %s", i, fails, formatError(results[i].Err))
+		}
+	}
+
+	if !errors.Is(results[1].Err, itunes.ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge, got %s", formatError(results[1].Err))
+	}
+
+	exp := "HEAD /image,HEAD /huge,HEAD /page,GET /page,HEAD /no-head,GET /no-head"
+	if got := strings.Join(methods, ","); got != exp {
+		t.Errorf("expected requests %s, got %s", exp, got)
+	}
+}
+
+func TestBatchPage(t *testing.T) {
+
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	mux.Handle("/short", http.RedirectHandler("/plist", http.StatusFound))
+	mux.HandleFunc("/plist", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write(itunestest.GotoPlist(ts.URL + "/us/podcast/show/id1?mt=2&l=es&ign-mpt=uo%3D4"))
+	})
+	mux.HandleFunc("/us/podcast/show/id1", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write(itunestest.Page("", "http://example.com/feed"))
+	})
+
+	b := &itunes.Batch{}
+	res := b.ToRSS([]string{ts.URL + "/short"})[0]
+
+	if res.Err != nil {
+		t.Fatalf("unexpected error %s", formatError(res.Err))
+	}
+
+	// Only the language parameter is kept.
+	if exp := ts.URL + "/us/podcast/show/id1?l=es"; res.Page != exp {
+		t.Errorf("expected page %q, got %q", exp, res.Page)
+	}
+}
+
+func TestBatchPageViewPodcast(t *testing.T) {
+
+	// The show ID of a WebObjects URL is in the query.
+	const viewPodcast = "https://itunes.apple.com/WebObjects/DZR.woa/wa/viewPodcast?cc=mx&l=en&id=1212558767"
+
+	b := &itunes.Batch{
+		Client: clientFunc(func(req *http.Request) (*http.Response, error) {
+			resp := &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": {"text/html"}},
+				Body:       ioutil.NopCloser(bytes.NewReader(itunestest.Page("", "http://example.com/feed"))),
+				Request:    req,
+			}
+			if req.URL.Path == "/plist" {
+				resp.Header.Set("Content-Type", "text/xml")
+				resp.Body = ioutil.NopCloser(bytes.NewReader(itunestest.GotoPlist(viewPodcast)))
+			}
+			return resp, nil
+		}),
+	}
+
+	res := b.ToRSS([]string{"https://itunes.apple.com/plist"})[0]
+
+	if res.Err != nil {
+		t.Fatalf("unexpected error %s", formatError(res.Err))
+	}
+
+	if exp := "https://itunes.apple.com/podcast/id1212558767?l=en"; res.Page != exp {
+		t.Errorf("expected page %q, got %q", exp, res.Page)
+	}
+}
+
+func TestBatchMetadata(t *testing.T) {
+
+	s := itunestest.NewServer(
+		itunestest.Show{ID: 1, Feed: "https://example.com/feeds/1", Hops: 2},
+		itunestest.Show{ID: 2, Status: http.StatusServiceUnavailable},
+	)
+	defer s.Close()
+
+	b := &itunes.Batch{
+		Client:    s.Client(),
+		HeadCheck: true,
+		Retries:   1,
+	}
+
+	results := b.ToRSS([]string{
+		"https://itunes.apple.com/us/podcast/id1",
+		"https://itunes.apple.com/us/podcast/id2",
+	})
+
+	exp := []struct {
+		Attempts int
+		Requests int
+		Strategy string
+	}{
+		// A HEAD check, two Goto plists and the show's page.
+		{1, 4, "feed-url attribute"},
+		// A HEAD check and two failed attempts.
+		{2, 3, ""},
+	}
+
+	for i, e := range exp {
+
+		res := results[i]
+
+		if res.Attempts != e.Attempts || res.Requests != e.Requests || res.Strategy != e.Strategy {
+			t.Errorf("%s: expected %d attempts, %d requests and strategy %q, got %d, %d and %q", res.URL, e.Attempts, e.Requests, e.Strategy, res.Attempts, res.Requests, res.Strategy)
+		}
+
+		if res.Duration <= 0 {
+			t.Errorf("%s: expected a duration, got %s", res.URL, res.Duration)
+		}
+	}
+}
+
+type rendererFunc func(url string) (string, error)
+
+func (f rendererFunc) Render(url string) (string, error) {
+	return f(url)
+}
+
+func TestBatchRenderer(t *testing.T) {
+
+	const feed = "http://feeds.serialpodcast.org/serialpodcast"
+
+	s := itunestest.NewServer(
+		itunestest.Show{ID: 1, Response: itunestest.ResponseNoFeed},
+		itunestest.Show{ID: 2, Response: itunestest.ResponseItemNotAvailable},
+		itunestest.Show{ID: 3, Feed: feed},
+	)
+	defer s.Close()
+
+	var rendered []string
+	b := &itunes.Batch{
+		Client: s.Client(),
+		Renderer: rendererFunc(func(url string) (string, error) {
+			rendered = append(rendered, url)
+			return string(itunestest.Page("", feed)), nil
+		}),
+	}
+
+	results := b.ToRSS([]string{
+		"https://itunes.apple.com/us/podcast/id1",
+		"https://itunes.apple.com/us/podcast/id2",
+		"https://itunes.apple.com/us/podcast/id3",
+	})
+
+	if results[0].Err != nil || results[0].Feed != feed {
+		t.Errorf("expected the rendered page to have feed %q, got %q (error %s)", feed, results[0].Feed, formatError(results[0].Err))
+	}
+
+	if results[0].Attempts != 2 {
+		t.Errorf("expected 2 attempts, got %d", results[0].Attempts)
+	}
+
+	if results[1].Err == nil {
+		t.Error("expected an error for the unavailable show")
+	}
+
+	if results[2].Err != nil || results[2].Feed != feed {
+		t.Errorf("expected feed %q, got %q (error %s)", feed, results[2].Feed, formatError(results[2].Err))
+	}
+
+	// Only the page without a feed is rendered.
+	if len(rendered) != 1 || !strings.HasSuffix(rendered[0], "/id1") {
+		t.Errorf("expected the page for show 1 to be rendered, got %v", rendered)
+	}
+}
+
+func TestBatchRendererErrors(t *testing.T) {
+
+	const audiobook = `<html><body><a twitter-content-url="https://itunes.apple.com/us/audiobook/dune/id1234?mt=3">Share</a></body></html>`
+
+	errRender := errors.New("browser crashed")
+
+	var rendered []string
+	b := &itunes.Batch{
+		Client: clientFunc(func(req *http.Request) (*http.Response, error) {
+			body := "<html><body></body></html>"
+			if strings.HasSuffix(req.URL.Path, "/id1234") {
+				body = audiobook
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": {"text/html"}},
+				Body:       ioutil.NopCloser(strings.NewReader(body)),
+				Request:    req,
+			}, nil
+		}),
+		Renderer: rendererFunc(func(url string) (string, error) {
+			rendered = append(rendered, url)
+			return "", errRender
+		}),
+	}
+
+	results := b.ToRSS([]string{
+		"https://itunes.apple.com/us/podcast/id1",
+		"https://itunes.apple.com/us/audiobook/id1234",
+	})
+
+	// Render errors are wrapped.
+	if err := results[0].Err; !errors.Is(err, errRender) {
+		t.Errorf("expected error to match %v, got %s", errRender, formatError(err))
+	}
+
+	// Pages of a known kind aren't rendered.
+	if err := results[1].Err; !errors.Is(err, itunes.ErrAudiobook) {
+		t.Errorf("expected error to match %v, got %s", itunes.ErrAudiobook, formatError(err))
+	}
+
+	if len(rendered) != 1 || !strings.HasSuffix(rendered[0], "/id1") {
+		t.Errorf("expected only the page for show 1 to be rendered, got %v", rendered)
+	}
+}
+
+func TestBatchOptions(t *testing.T) {
+
+	const feed = "http://feeds.serialpodcast.org/serialpodcast"
+
+	s := itunestest.NewServer(itunestest.Show{ID: 1, Feed: feed})
+	defer s.Close()
+
+	u := "https://itunes.apple.com/us/podcast/id1"
+
+	b := &itunes.Batch{Client: s.Client()}
+	if res := b.ToRSS([]string{u})[0]; res.Err != nil || res.Feed != feed {
+		t.Errorf("expected feed %q, got %q (error %s)", feed, res.Feed, formatError(res.Err))
+	}
+
+	b.Options.MaxPageSize = 100
+	if res := b.ToRSS([]string{u})[0]; !errors.Is(res.Err, itunes.ErrTooLarge) {
+		t.Errorf("expected error %s, got %s", formatError(itunes.ErrTooLarge), formatError(res.Err))
+	}
+}
@@ -0,0 +1,343 @@
+package itunes
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultCacheEntries is the most responses that a cache
+// transport keeps if its size isn't set.
+const defaultCacheEntries = 1000
+
+// NewCacheTransport returns an http.RoundTripper that caches
+// successful GET responses from next in memory, following the
+// rules of RFC 7234 for a private cache. Responses are reused
+// while they're fresh according to their Cache-Control max-age
+// or Expires headers, and revalidated with their ETag or
+// Last-Modified headers once they're stale. Responses marked
+// no-store, or that Vary on every header, aren't cached. There
+// is no heuristic freshness, so a response without freshness
+// or validation headers is never reused. The transport keeps
+// at most maxEntries responses, dropping the oldest when it is
+// full. If maxEntries is zero, it defaults to 1000.
+//
+// Requests from the ToRSS functions don't vary from one call
+// to the next, so repeat lookups of the same show can be served
+// from the cache, even if its URL is spelled differently. If next is nil, http.DefaultTransport is
+// used. NewClient uses it when ClientOptions.Cache is set.
+// The transport is safe for concurrent use by multiple
+// goroutines.
+func NewCacheTransport(next http.RoundTripper, maxEntries int) http.RoundTripper {
+	return newCacheTransport(next, maxEntries, nil)
+}
+
+func newCacheTransport(next http.RoundTripper, maxEntries int, clock Clock) *cacheTransport {
+
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	if maxEntries <= 0 {
+		maxEntries = defaultCacheEntries
+	}
+
+	return &cacheTransport{
+		next:       next,
+		clock:      clockOrDefault(clock),
+		maxEntries: maxEntries,
+		entries:    map[string]*cacheEntry{},
+	}
+}
+
+type cacheTransport struct {
+	next       http.RoundTripper
+	clock      Clock
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	order   []string
+}
+
+// A cacheEntry is a stored response.
+type cacheEntry struct {
+	status int
+	header http.Header
+	body   []byte
+
+	// vary holds the values of the request headers named in
+	// the response's Vary header.
+	vary map[string]string
+
+	// expires is when the response becomes stale.
+	expires time.Time
+}
+
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	if req.Method != "GET" || req.Header.Get("Range") != "" || hasDirective(req.Header, "no-store") {
+		return t.next.RoundTrip(req)
+	}
+
+	key := cacheKey(req.URL)
+	e := t.get(key)
+	if e != nil && !e.matches(req) {
+		e = nil
+	}
+
+	now := t.clock.Now()
+	if e != nil && now.Before(e.expires) && !hasDirective(req.Header, "no-cache") {
+		return e.response(req), nil
+	}
+
+	out := req
+	if e != nil {
+		out = revalidate(req, e)
+	}
+
+	resp, err := t.next.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+
+	if e != nil && resp.StatusCode == http.StatusNotModified {
+		closeBody(resp.Body)
+		e = e.update(resp.Header, t.clock.Now())
+		t.put(key, e)
+		return e.response(req), nil
+	}
+
+	if !cacheable(resp, t.clock.Now()) {
+		return resp, nil
+	}
+
+	// Don't buffer bodies that are too big for the package
+	// to read anyway.
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, defaultMaxPageSize+1))
+	if err != nil || int64(len(body)) > defaultMaxPageSize {
+		resp.Body = &prefixBody{
+			Reader: io.MultiReader(bytes.NewReader(body), resp.Body),
+			Closer: resp.Body,
+		}
+		return resp, nil
+	}
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	t.put(key, &cacheEntry{
+		status:  resp.StatusCode,
+		header:  resp.Header.Clone(),
+		body:    body,
+		vary:    varyValues(req, resp.Header),
+		expires: expiry(resp.Header, t.clock.Now()),
+	})
+
+	return resp, nil
+}
+
+// cacheKey returns the key that a response to a request for u
+// is stored under. Show URLs are keyed by their ShowURL, so that
+// different spellings of the same show, e.g. with or without a
+// slug or tracking tokens, share an entry.
+func cacheKey(u *url.URL) string {
+
+	s := u.String()
+	if show, err := ParseURL(s); err == nil {
+		return show.String()
+	}
+
+	return s
+}
+
+func (t *cacheTransport) get(key string) *cacheEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.entries[key]
+}
+
+func (t *cacheTransport) put(key string, e *cacheEntry) {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.entries[key]; !ok {
+		t.order = append(t.order, key)
+	}
+	t.entries[key] = e
+
+	for len(t.order) > t.maxEntries && len(t.order) > 0 {
+		delete(t.entries, t.order[0])
+		t.order = t.order[1:]
+	}
+}
+
+// matches reports whether req has the same values as the
+// stored request for the headers that the response varies on.
+func (e *cacheEntry) matches(req *http.Request) bool {
+	for name, val := range e.vary {
+		if req.Header.Get(name) != val {
+			return false
+		}
+	}
+	return true
+}
+
+// response returns a new response for req from the entry.
+func (e *cacheEntry) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        strconv.Itoa(e.status) + " " + http.StatusText(e.status),
+		StatusCode:    e.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.header.Clone(),
+		Body:          ioutil.NopCloser(bytes.NewReader(e.body)),
+		ContentLength: int64(len(e.body)),
+		Request:       req,
+	}
+}
+
+// update returns a copy of the entry with the headers of a
+// 304 Not Modified response received at now.
+func (e *cacheEntry) update(h http.Header, now time.Time) *cacheEntry {
+
+	updated := *e
+	updated.header = e.header.Clone()
+
+	for name, vals := range h {
+		if name == "Content-Length" {
+			continue
+		}
+		updated.header[name] = vals
+	}
+
+	updated.expires = expiry(updated.header, now)
+
+	return &updated
+}
+
+// revalidate returns a copy of req that asks the server
+// whether the entry is still valid.
+func revalidate(req *http.Request, e *cacheEntry) *http.Request {
+
+	out := req.Clone(req.Context())
+
+	if etag := e.header.Get("ETag"); etag != "" {
+		out.Header.Set("If-None-Match", etag)
+	}
+
+	if lm := e.header.Get("Last-Modified"); lm != "" {
+		out.Header.Set("If-Modified-Since", lm)
+	}
+
+	return out
+}
+
+// cacheable reports whether resp, received at now, can be
+// stored.
+func cacheable(resp *http.Response, now time.Time) bool {
+
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+
+	h := resp.Header
+	if hasDirective(h, "no-store") || h.Get("Vary") == "*" {
+		return false
+	}
+
+	return now.Before(expiry(h, now)) || h.Get("ETag") != "" || h.Get("Last-Modified") != ""
+}
+
+// expiry returns when a response with headers h, received at
+// now, becomes stale. A response without freshness headers, or
+// with a no-cache directive, is stale immediately.
+func expiry(h http.Header, now time.Time) time.Time {
+
+	if hasDirective(h, "no-cache") {
+		return now
+	}
+
+	age := time.Duration(0)
+	if n, err := strconv.Atoi(h.Get("Age")); err == nil && n > 0 {
+		age = time.Duration(n) * time.Second
+	}
+
+	if v, ok := directive(h, "max-age"); ok {
+		if n, err := strconv.Atoi(v); err == nil {
+			return now.Add(time.Duration(n)*time.Second - age)
+		}
+		return now
+	}
+
+	if s := h.Get("Expires"); s != "" {
+		expires, err := http.ParseTime(s)
+		if err != nil {
+			return now
+		}
+		date, err := http.ParseTime(h.Get("Date"))
+		if err != nil {
+			date = now
+		}
+		return now.Add(expires.Sub(date) - age)
+	}
+
+	return now
+}
+
+// varyValues returns the values in req of the headers named
+// in the Vary header of a response.
+func varyValues(req *http.Request, h http.Header) map[string]string {
+
+	vary := map[string]string{}
+
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				vary[name] = req.Header.Get(name)
+			}
+		}
+	}
+
+	return vary
+}
+
+// hasDirective reports whether h has the Cache-Control
+// directive name.
+func hasDirective(h http.Header, name string) bool {
+	_, ok := directive(h, name)
+	return ok
+}
+
+// directive returns the value of the Cache-Control directive
+// name in h.
+func directive(h http.Header, name string) (string, bool) {
+
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			key, val := strings.TrimSpace(d), ""
+			if i := strings.Index(key, "="); i >= 0 {
+				key, val = strings.TrimSpace(key[:i]), strings.Trim(strings.TrimSpace(key[i+1:]), `"`)
+			}
+			if strings.EqualFold(key, name) {
+				return val, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// A prefixBody is a response body that has been partly read
+// into memory.
+type prefixBody struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,212 @@
+package itunes_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/deepilla/itunes"
+	"github.com/deepilla/itunes/itunestest"
+)
+
+func TestCacheTransport(t *testing.T) {
+
+	const (
+		feed = "http://feeds.serialpodcast.org/serialpodcast"
+		etag = `"v1"`
+	)
+
+	var mu sync.Mutex
+	hits := map[string]int{}
+	var accepts []string
+
+	mux := http.NewServeMux()
+	handle := func(path string, fn func(w http.ResponseWriter, r *http.Request) bool) {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			hits[path]++
+			accepts = append(accepts, r.Header.Get("Accept"))
+			mu.Unlock()
+			if fn(w, r) {
+				w.Header().Set("Content-Type", "text/html")
+				w.Write(itunestest.Page("Serial", feed))
+			}
+		})
+	}
+
+	handle("/max-age", func(w http.ResponseWriter, r *http.Request) bool {
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		return true
+	})
+	handle("/etag", func(w http.ResponseWriter, r *http.Request) bool {
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return false
+		}
+		return true
+	})
+	handle("/no-store", func(w http.ResponseWriter, r *http.Request) bool {
+		w.Header().Set("Cache-Control", "no-store, max-age=60")
+		return true
+	})
+	handle("/vary", func(w http.ResponseWriter, r *http.Request) bool {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "*")
+		return true
+	})
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	client, err := itunes.NewClient(itunes.ClientOptions{Cache: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string]int{
+		"/max-age":  1,
+		"/etag":     3,
+		"/no-store": 3,
+		"/vary":     3,
+	}
+
+	for path, n := range exp {
+		for i := 0; i < 3; i++ {
+
+			got, err := itunes.ToRSSClient(ts.URL+path, client)
+			if err != nil {
+				t.Errorf("%s: unexpected error %s", path, formatError(err))
+				continue
+			}
+
+			if got != feed {
+				t.Errorf("%s: expected feed %q, got %q", path, feed, got)
+			}
+		}
+
+		if hits[path] != n {
+			t.Errorf("%s: expected %d requests to the server, got %d", path, n, hits[path])
+		}
+	}
+
+	// Requests don't vary, so caches can reuse responses.
+	for _, a := range accepts[1:] {
+		if a == "" || a != accepts[0] {
+			t.Errorf("expected the same Accept header on every request, got %q", accepts)
+			break
+		}
+	}
+}
+
+func TestCacheClock(t *testing.T) {
+
+	hits := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Content-Type", "text/html")
+		w.Write(itunestest.Page("Serial", "http://feeds.serialpodcast.org/serialpodcast"))
+	}))
+	defer ts.Close()
+
+	clock := &fakeClock{now: time.Date(2017, 3, 28, 10, 0, 0, 0, time.UTC)}
+
+	client, err := itunes.NewClient(itunes.ClientOptions{
+		Cache: true,
+		Clock: clock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, wait := range []time.Duration{0, 59 * time.Second, 2 * time.Second} {
+
+		clock.Sleep(wait)
+
+		if _, err := itunes.ToRSSClient(ts.URL, client); err != nil {
+			t.Fatalf("unexpected error %s", formatError(err))
+		}
+	}
+
+	// The response goes stale after a minute.
+	if hits != 2 {
+		t.Errorf("expected 2 requests to the server, got %d", hits)
+	}
+}
+
+func TestCacheEntries(t *testing.T) {
+
+	hits := map[string]int{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits[r.URL.Path]++
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Content-Type", "text/html")
+		w.Write(itunestest.Page("Serial", "http://feeds.serialpodcast.org/serialpodcast"))
+	}))
+	defer ts.Close()
+
+	client := &http.Client{
+		Transport: itunes.NewCacheTransport(nil, 1),
+	}
+
+	for _, path := range []string{"/1", "/1", "/2", "/1"} {
+		if _, err := itunes.ToRSSClient(ts.URL+path, client); err != nil {
+			t.Fatalf("unexpected error %s", formatError(err))
+		}
+	}
+
+	// The cache only has room for one response, so the second
+	// show pushes out the first.
+	if hits["/1"] != 2 || hits["/2"] != 1 {
+		t.Errorf("expected 2 requests for /1 and 1 for /2, got %v", hits)
+	}
+}
+
+func TestCacheShowURLs(t *testing.T) {
+
+	hits := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Content-Type", "text/html")
+		w.Write(itunestest.Page("Serial", "http://feeds.serialpodcast.org/serialpodcast"))
+	}))
+	defer ts.Close()
+
+	base, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{
+		Transport: itunes.NewCacheTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			req = req.Clone(req.Context())
+			req.URL.Scheme = base.Scheme
+			req.URL.Host = base.Host
+			return http.DefaultTransport.RoundTrip(req)
+		}), 0),
+	}
+
+	urls := []string{
+		"https://itunes.apple.com/us/podcast/serial/id917918570?mt=2",
+		"https://itunes.apple.com/us/podcast/id917918570",
+		"https://podcasts.apple.com/us/podcast/serial/id917918570?at=1l3v&ct=newsletter",
+		// Other storefronts are different pages.
+		"https://itunes.apple.com/gb/podcast/serial/id917918570",
+	}
+
+	for _, u := range urls {
+		if _, err := itunes.ToRSSClient(u, client); err != nil {
+			t.Fatalf("%s: unexpected error %s", u, formatError(err))
+		}
+	}
+
+	if hits != 2 {
+		t.Errorf("expected 2 requests to the server, got %d", hits)
+	}
+}
@@ -0,0 +1,134 @@
+package itunes
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ClientOptions configures the HTTP client returned by
+// NewClient. The zero value is ready to use.
+type ClientOptions struct {
+	// Proxy, if set, is the URL of the proxy to send requests
+	// through. Its scheme must be http, https or socks5.
+	Proxy string
+
+	// DialContext, if set, opens the network connections.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// Timeout, if set, limits the time taken by each request.
+	Timeout time.Duration
+
+	// Cache enables an in-memory HTTP cache, as returned by
+	// NewCacheTransport. Each client has its own cache.
+	Cache bool
+
+	// CacheEntries is the most responses that the cache keeps.
+	// If zero, it defaults to 1000.
+	CacheEntries int
+
+	// Clock, if set, tells the cache the time. If nil,
+	// SystemClock is used.
+	Clock Clock
+}
+
+// NewClient returns an *http.Client configured with opts, for
+// use with the ToRSS functions. Use it to route requests to
+// Apple through a proxy or a custom dialer without writing a
+// Client.
+func NewClient(opts ClientOptions) (*http.Client, error) {
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+
+	if opts.Proxy != "" {
+		u, err := url.Parse(opts.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("bad proxy URL: %s", err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+		}
+		t.Proxy = http.ProxyURL(u)
+	}
+
+	if opts.DialContext != nil {
+		t.DialContext = opts.DialContext
+	}
+
+	var rt http.RoundTripper = t
+	if opts.Cache {
+		rt = newCacheTransport(t, opts.CacheEntries, opts.Clock)
+	}
+
+	return &http.Client{
+		Transport: rt,
+		Timeout:   opts.Timeout,
+	}, nil
+}
+
+// LimitClient returns a Client that sends at most n requests
+// through client at a time. Other requests wait for a slot.
+// A request holds its slot until its response body is closed,
+// so the limit applies to open connections. The returned
+// Client is safe for concurrent use. If client is nil,
+// the default HTTP client is used. If n is less than 1, it is
+// treated as 1.
+func LimitClient(client Client, n int) Client {
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	if n < 1 {
+		n = 1
+	}
+
+	return &limitClient{
+		client: client,
+		sem:    make(chan struct{}, n),
+	}
+}
+
+type limitClient struct {
+	client Client
+	sem    chan struct{}
+}
+
+func (c *limitClient) Do(req *http.Request) (*http.Response, error) {
+
+	c.sem <- struct{}{}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		<-c.sem
+		return nil, err
+	}
+
+	resp.Body = &releaseBody{
+		ReadCloser: resp.Body,
+		release:    func() { <-c.sem },
+	}
+
+	return resp, nil
+}
+
+// A releaseBody is a response body that frees its request's
+// slot in a limitClient when it is first closed.
+type releaseBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
@@ -0,0 +1,315 @@
+package itunes_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/deepilla/itunes"
+	"github.com/deepilla/itunes/itunestest"
+)
+
+const showURL = "http://itunes.apple.com/us/podcast/serial/id917918570"
+
+func TestNewClientProxy(t *testing.T) {
+
+	const feed = "http://feeds.serialpodcast.org/serialpodcast"
+
+	s := itunestest.NewServer(itunestest.Show{ID: 917918570, Feed: feed})
+	defer s.Close()
+
+	// The proxy forwards every request to the fake server.
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+		req, _ := http.NewRequest(r.Method, r.URL.String(), nil)
+		req.Header = r.Header
+		resp, err := s.Client().Do(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	}))
+	defer proxy.Close()
+
+	client, err := itunes.NewClient(itunes.ClientOptions{
+		Proxy: proxy.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := itunes.ToRSSClient(showURL, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != feed {
+		t.Errorf("expected feed %q, got %q", feed, got)
+	}
+
+	if len(proxied) != 1 || proxied[0] != showURL {
+		t.Errorf("expected one proxied request for %s, got %v", showURL, proxied)
+	}
+}
+
+func TestNewClientDialContext(t *testing.T) {
+
+	const feed = "http://feeds.serialpodcast.org/serialpodcast"
+
+	s := itunestest.NewServer(itunestest.Show{ID: 917918570, Feed: feed})
+	defer s.Close()
+
+	addr := s.URL[len("http://"):]
+
+	var dialed []string
+	client, err := itunes.NewClient(itunes.ClientOptions{
+		DialContext: func(ctx context.Context, network, a string) (net.Conn, error) {
+			dialed = append(dialed, a)
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := itunes.ToRSSClient(showURL, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != feed {
+		t.Errorf("expected feed %q, got %q", feed, got)
+	}
+
+	if len(dialed) != 1 || dialed[0] != "itunes.apple.com:80" {
+		t.Errorf("expected one dial to itunes.apple.com:80, got %v", dialed)
+	}
+}
+
+func TestNewClientBadProxy(t *testing.T) {
+
+	for _, proxy := range []string{"ftp://proxy.example.com", "http://%zz"} {
+		if _, err := itunes.NewClient(itunes.ClientOptions{Proxy: proxy}); err == nil {
+			t.Errorf("%s: expected an error", proxy)
+		}
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+
+	done := make(chan struct{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow-headers", func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	})
+	mux.HandleFunc("/slow-body", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html>"))
+		w.(http.Flusher).Flush()
+		<-done
+	})
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	// Release the handlers before the server closes.
+	defer close(done)
+
+	client, err := itunes.NewClient(itunes.ClientOptions{
+		Timeout: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/slow-headers", "/slow-body"} {
+
+		_, err := itunes.ToRSSClient(ts.URL+path, client)
+		if err == nil {
+			t.Errorf("%s: expected an error, got nil", path)
+			continue
+		}
+
+		ne, ok := err.(net.Error)
+		if !ok || !ne.Timeout() {
+			t.Errorf("%s: expected a net.Error that timed out, got %s", path, formatError(err))
+		}
+
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: expected error to match context.DeadlineExceeded, got %s", path, formatError(err))
+		}
+	}
+}
+
+func TestCanceled(t *testing.T) {
+
+	requests := 0
+	client := clientFunc(func(req *http.Request) (*http.Response, error) {
+		requests++
+		return nil, &url.Error{Op: "Get", URL: req.URL.String(), Err: context.Canceled}
+	})
+
+	b := &itunes.Batch{
+		Client:  client,
+		Retries: 3,
+	}
+
+	err := b.ToRSS([]string{showURL})[0].Err
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected error to match context.Canceled, got %s", formatError(err))
+	}
+
+	if ne, ok := err.(net.Error); !ok || ne.Temporary() {
+		t.Errorf("expected a net.Error that isn't temporary, got %s", formatError(err))
+	}
+
+	if requests != 1 {
+		t.Errorf("expected 1 request, got %d", requests)
+	}
+}
+
+func TestLimitClient(t *testing.T) {
+
+	const limit = 2
+
+	var mu sync.Mutex
+	var active, peak int
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		mu.Lock()
+		active++
+		if active > peak {
+			peak = active
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/html")
+		w.Write(itunestest.Page("Show", "https://example.com/feed"))
+	}))
+	defer ts.Close()
+
+	client := itunes.LimitClient(http.DefaultClient, limit)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := itunes.ToRSSClient(ts.URL, client); err != nil {
+				t.Errorf("unexpected error %s", formatError(err))
+			}
+		}()
+	}
+	wg.Wait()
+
+	if peak > limit {
+		t.Errorf("expected at most %d requests in flight, got %d", limit, peak)
+	}
+}
+
+func TestConcurrentUse(t *testing.T) {
+
+	const feed = "http://feeds.serialpodcast.org/serialpodcast"
+
+	s := itunestest.NewServer(
+		itunestest.Show{ID: 917918570, Feed: feed, Hops: 1},
+		itunestest.Show{ID: 1212558767, Status: http.StatusServiceUnavailable},
+	)
+	defer s.Close()
+
+	base, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	toServer := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		req = req.Clone(req.Context())
+		req.URL.Scheme = base.Scheme
+		req.URL.Host = base.Host
+		return http.DefaultTransport.RoundTrip(req)
+	})
+
+	// One client and one Batch, shared by every goroutine.
+	client := itunes.LimitClient(&http.Client{
+		Transport: itunes.NewCacheTransport(toServer, 0),
+	}, 4)
+
+	b := &itunes.Batch{
+		Client:          client,
+		Retries:         1,
+		Jitter:          itunes.FullJitter,
+		RequestIDHeader: "X-Request-Id",
+	}
+
+	// A second Batch with its own settings runs alongside.
+	small := &itunes.Batch{
+		Client:  client,
+		Options: itunes.Options{MaxPageSize: 100, HarvestFeeds: true},
+	}
+
+	urls := []string{
+		"https://itunes.apple.com/us/podcast/serial/id917918570",
+		"https://itunes.apple.com/us/podcast/id1212558767",
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results := b.ToRSS(urls)
+			if results[0].Feed != feed || results[1].Err == nil {
+				t.Errorf("unexpected results %+v", results)
+			}
+			if res := small.ToRSS(urls[:1])[0]; !errors.Is(res.Err, itunes.ErrTooLarge) {
+				t.Errorf("expected error %s, got %s", formatError(itunes.ErrTooLarge), formatError(res.Err))
+			}
+			if _, err := itunes.ToRSSClient(urls[0], client); err != nil {
+				t.Errorf("unexpected error %s", formatError(err))
+			}
+			itunes.RegisterExtractor(fmt.Sprintf("concurrent %d", atomic.AddInt32(&concurrentExtractors, 1)), nopExtractor{})
+		}()
+	}
+	wg.Wait()
+}
+
+// concurrentExtractors numbers the Extractors registered by
+// TestConcurrentUse, so that their names are unique if the test
+// runs more than once.
+var concurrentExtractors int32
+
+// A nopExtractor doesn't handle any responses.
+type nopExtractor struct{}
+
+func (nopExtractor) CanHandle(contentType string, peek []byte) bool { return false }
+func (nopExtractor) Extract(r io.Reader) (itunes.Outcome, error)    { return itunes.Outcome{}, nil }
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,30 @@
+package itunes
+
+import "time"
+
+// A Clock tells the time and waits. Batch uses one for its
+// retry delays and timings, the cache transport from NewClient
+// uses one to decide when responses go stale, and the crawl
+// package uses one to space out requests. Provide your own
+// implementation to test time-dependent code without waiting.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+// SystemClock is the Clock used when none is provided. It
+// reads and waits on the system clock with package time.
+var SystemClock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time        { return time.Now() }
+func (realClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// clockOrDefault returns c, or SystemClock if c is nil.
+func clockOrDefault(c Clock) Clock {
+	if c == nil {
+		return SystemClock
+	}
+	return c
+}
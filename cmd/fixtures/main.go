@@ -0,0 +1,320 @@
+// Command fixtures downloads the iTunes pages for a list of
+// shows and saves them as test fixtures.
+//
+// Usage:
+//
+//	fixtures [-config file] [-dir dir] [show dir...]
+//
+// The config file is a JSON array of objects with "dir" and
+// "url" fields. Each show's responses are saved in dir, relative
+// to the fixtures directory. The final HTML page is saved as
+// itunes-page. Any Goto plists along the way are saved as plist
+// or, if there are several, plist-1, plist-2 and so on, counting
+// back from the page. Goto URLs are rewritten to point at the
+// next saved fixture so that the tests never hit Apple.
+//
+// Fixtures are sanitized before they are saved: Apple URLs with
+// affiliate or campaign tokens (at= or ct=) are cleaned with
+// itunes.StripAffiliate, which also removes their media type
+// token (mt=). Apple URLs without at= or ct= are left as is.
+// Only response bodies are saved, so headers like Set-Cookie
+// never reach the fixtures.
+//
+// If show dirs are given, only those shows are downloaded.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/deepilla/itunes"
+)
+
+// userAgent matches the User Agent used by the itunes package.
+const userAgent = "iTunes/10.1"
+
+const maxHops = 10
+
+type show struct {
+	Dir string `json:"dir"`
+	URL string `json:"url"`
+}
+
+// A response is a downloaded iTunes page or plist.
+type response struct {
+	body []byte
+	next string // Goto URL, plists only
+}
+
+func main() {
+
+	config := flag.String("config", "testdata/fixtures.json", "JSON list of shows to download")
+	dir := flag.String("dir", "testdata", "fixtures directory")
+	flag.Parse()
+
+	log.SetFlags(0)
+	log.SetPrefix("fixtures: ")
+
+	shows, err := loadConfig(*config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	shows = filterShows(shows, flag.Args())
+	if len(shows) == 0 {
+		log.Fatal("no shows to download")
+	}
+
+	failed := false
+	for _, s := range shows {
+		if err := download(s, *dir); err != nil {
+			log.Printf("%s: %s", s.Dir, err)
+			failed = true
+			continue
+		}
+		log.Printf("%s: ok", s.Dir)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func loadConfig(filename string) ([]show, error) {
+
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var shows []show
+	if err := json.Unmarshal(b, &shows); err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+
+	return shows, nil
+}
+
+func filterShows(shows []show, dirs []string) []show {
+
+	if len(dirs) == 0 {
+		return shows
+	}
+
+	want := map[string]bool{}
+	for _, d := range dirs {
+		want[path.Clean(d)] = true
+	}
+
+	var filtered []show
+	for _, s := range shows {
+		if want[path.Clean(s.Dir)] {
+			filtered = append(filtered, s)
+		}
+	}
+
+	return filtered
+}
+
+func download(s show, root string) error {
+
+	var resps []response
+
+	u := s.URL
+	for {
+		if len(resps) == maxHops {
+			return errors.New("too many redirects")
+		}
+
+		resp, err := get(u)
+		if err != nil {
+			return err
+		}
+
+		resps = append(resps, resp)
+		if resp.next == "" {
+			break
+		}
+
+		u = resp.next
+	}
+
+	names := fixtureNames(len(resps))
+
+	for i := range resps[:len(resps)-1] {
+		next, err := localURL(resps[i].next, path.Join(s.Dir, names[i+1]))
+		if err != nil {
+			return err
+		}
+		if err := rewriteGoto(&resps[i], next); err != nil {
+			return err
+		}
+	}
+
+	dir := filepath.Join(root, filepath.FromSlash(s.Dir))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	// Remove old plists in case the number of hops has changed.
+	old, err := filepath.Glob(filepath.Join(dir, "plist*"))
+	if err != nil {
+		return err
+	}
+	for _, f := range old {
+		if err := os.Remove(f); err != nil {
+			return err
+		}
+	}
+
+	for i, resp := range resps {
+		if err := ioutil.WriteFile(filepath.Join(dir, names[i]), sanitize(resp.body), 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func get(u string) (response, error) {
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return response{}, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return response{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return response{}, fmt.Errorf("%s: %s", u, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return response{}, fmt.Errorf("%s: %s", u, err)
+	}
+
+	ctype := resp.Header.Get("Content-Type")
+	media, _, err := mime.ParseMediaType(ctype)
+	if err != nil {
+		return response{}, fmt.Errorf("%s: bad Content Type %q: %s", u, ctype, err)
+	}
+
+	switch media {
+	case "text/html":
+		return response{body: body}, nil
+
+	case "text/xml", "application/xml":
+		target, err := itunes.ParsePlist(bytes.NewReader(body))
+		if err != nil {
+			return response{}, fmt.Errorf("%s: %s", u, err)
+		}
+		return response{body: body, next: target.URL}, nil
+
+	default:
+		return response{}, fmt.Errorf("%s: unsupported Content Type %q", u, ctype)
+	}
+}
+
+// fixtureNames returns the filenames for a chain of n responses.
+func fixtureNames(n int) []string {
+
+	names := make([]string, n)
+	names[n-1] = "itunes-page"
+
+	switch n {
+	case 1:
+	case 2:
+		names[0] = "plist"
+	default:
+		for i := 0; i < n-1; i++ {
+			names[i] = fmt.Sprintf("plist-%d", n-1-i)
+		}
+	}
+
+	return names
+}
+
+// localURL returns the path of a saved fixture, keeping the
+// query string of the original URL.
+func localURL(orig, fixture string) (string, error) {
+
+	u, err := url.Parse(orig)
+	if err != nil {
+		return "", err
+	}
+
+	local := &url.URL{
+		Path:     fixture,
+		RawQuery: u.RawQuery,
+	}
+
+	return local.String(), nil
+}
+
+// rewriteGoto points the Goto URL of a plist at a new location.
+func rewriteGoto(resp *response, u string) error {
+
+	old := []byte("<string>" + html.EscapeString(resp.next) + "</string>")
+	if bytes.Count(resp.body, old) != 1 {
+		return fmt.Errorf("can't find Goto URL %q in plist", resp.next)
+	}
+
+	resp.body = bytes.Replace(resp.body, old, []byte("<string>"+html.EscapeString(u)+"</string>"), 1)
+	resp.next = u
+
+	return nil
+}
+
+var (
+	// Matches an absolute URL in a page or plist.
+	reURL = regexp.MustCompile(`https?://[^\s"'<>\\]+`)
+
+	// Matches an affiliate or campaign token in a query string.
+	reToken = regexp.MustCompile(`[?&](?:amp;)?(?:at|ct)=`)
+)
+
+// sanitize removes affiliate and campaign tokens, and with them
+// the mt= token, from the Apple URLs in a response body. URLs
+// in HTML may have their ampersands escaped.
+func sanitize(body []byte) []byte {
+	return reURL.ReplaceAllFunc(body, func(m []byte) []byte {
+
+		if !reToken.Match(m) {
+			return m
+		}
+
+		escaped := bytes.Contains(m, []byte("&amp;"))
+		u := strings.Replace(string(m), "&amp;", "&", -1)
+
+		clean, err := itunes.StripAffiliate(u)
+		if err != nil {
+			return m
+		}
+
+		if escaped {
+			clean = strings.Replace(clean, "&", "&amp;", -1)
+		}
+
+		return []byte(clean)
+	})
+}
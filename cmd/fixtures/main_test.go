@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSanitize(t *testing.T) {
+
+	data := map[string]string{
+		// Tokens are removed from Apple URLs, escaped or not.
+		`<a href="https://itunes.apple.com/us/podcast/id1?at=1l3v&amp;ct=spring&amp;l=es">`: `<a href="https://itunes.apple.com/us/podcast/id1?l=es">`,
+		`<string>https://podcasts.apple.com/us/podcast/id1?l=en&at=1l3v</string>`:           `<string>https://podcasts.apple.com/us/podcast/id1?l=en</string>`,
+		`<a href="https://itunes.apple.com/us/podcast/id1?mt=2&amp;ct=spring">`:             `<a href="https://itunes.apple.com/us/podcast/id1">`,
+		// Other URLs are left alone.
+		`<a href="https://example.com/feed?at=1">`:                     `<a href="https://example.com/feed?at=1">`,
+		`<a href="https://itunes.apple.com/us/podcast/id1?mt=2&l=en">`: `<a href="https://itunes.apple.com/us/podcast/id1?mt=2&l=en">`,
+	}
+
+	for in, exp := range data {
+		if got := string(sanitize([]byte(in))); got != exp {
+			t.Errorf("%s: expected %s, got %s", in, exp, got)
+		}
+	}
+}
+
+func TestDownloadSanitizes(t *testing.T) {
+
+	page := `<html><body>
+<a href="https://itunes.apple.com/us/podcast/serial/id917918570?mt=2&amp;at=1l3v&amp;ct=newsletter">Serial</a>
+<a feed-url="http://feeds.serialpodcast.org/serialpodcast">Subscribe</a>
+</body></html>`
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret"})
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(page))
+	}))
+	defer ts.Close()
+
+	root := t.TempDir()
+	if err := download(show{Dir: "podcasts/serial", URL: ts.URL}, root); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(root, "podcasts", "serial", "itunes-page"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []string{"at=", "ct=", "session", "secret"} {
+		if strings.Contains(string(b), s) {
+			t.Errorf("expected the fixture not to contain %q, got\n%s", s, b)
+		}
+	}
+
+	if !strings.Contains(string(b), "http://feeds.serialpodcast.org/serialpodcast") {
+		t.Errorf("expected the fixture to keep the feed, got\n%s", b)
+	}
+}
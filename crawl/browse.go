@@ -0,0 +1,160 @@
+package crawl
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"regexp"
+
+	"golang.org/x/net/html"
+
+	"github.com/deepilla/itunes"
+)
+
+const defaultMaxPages = 100
+
+// Browse is a Source that finds shows on Apple Podcasts genre
+// ("browse") pages, e.g.
+// https://podcasts.apple.com/us/genre/podcasts-arts/id1301.
+// The key for each page is its URL.
+type Browse struct {
+	// URLs are the genre pages to read.
+	URLs []string
+
+	// FollowPages enables following pagination links, i.e.
+	// links to the same page with a different query string,
+	// such as "see all" or A-Z listings.
+	FollowPages bool
+
+	// MaxPages is the maximum number of pages to read for each
+	// URL when FollowPages is set. If zero, it defaults to 100.
+	MaxPages int
+}
+
+// Lists implements Source.
+func (b *Browse) Lists(client itunes.Client, fn func(key string, shows []Show) error) error {
+
+	for _, start := range b.URLs {
+
+		base, err := url.Parse(start)
+		if err != nil {
+			return fmt.Errorf("browse %s: %w", start, err)
+		}
+
+		genre := ""
+		if m := reGenrePath.FindStringSubmatch(base.Path); m != nil {
+			genre = m[1]
+		}
+
+		queue := []string{base.String()}
+		visited := map[string]bool{base.String(): true}
+
+		for n := 0; len(queue) > 0 && n < b.maxPages(); n++ {
+
+			page := queue[0]
+			queue = queue[1:]
+
+			body, err := get(client, page)
+			if err != nil {
+				return fmt.Errorf("browse %s: %w", page, err)
+			}
+
+			shows, pages := parseBrowse(body, page, base)
+			for i := range shows {
+				shows[i].Genre = genre
+			}
+
+			if err := fn(page, shows); err != nil {
+				return err
+			}
+
+			if !b.FollowPages {
+				break
+			}
+
+			for _, p := range pages {
+				if !visited[p] {
+					visited[p] = true
+					queue = append(queue, p)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (b *Browse) maxPages() int {
+	if b.MaxPages <= 0 {
+		return defaultMaxPages
+	}
+	return b.MaxPages
+}
+
+// Matches a genre page's URL path, e.g. /us/genre/podcasts-arts/id1301.
+var reGenrePath = regexp.MustCompile(`/genre/(?:[^/]+/)?id(\d+)$`)
+
+// parseBrowse returns the shows linked from a browse page, in
+// order of first appearance, and its pagination links, i.e.
+// links to base with a different query string.
+func parseBrowse(body []byte, pageURL string, base *url.URL) ([]Show, []string) {
+
+	page, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, nil
+	}
+
+	var shows []Show
+	var pages []string
+	seen := map[string]bool{}
+
+	z := html.NewTokenizer(bytes.NewReader(body))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		if tt != html.StartTagToken {
+			continue
+		}
+
+		tag, hasAttrs := z.TagName()
+		if string(tag) != "a" {
+			continue
+		}
+
+		var href string
+		for hasAttrs {
+			var key, val []byte
+			key, val, hasAttrs = z.TagAttr()
+			if string(key) == "href" {
+				href = string(val)
+			}
+		}
+
+		u, err := page.Parse(href)
+		if err != nil {
+			continue
+		}
+		u.Fragment = ""
+
+		if m := reShowPath.FindStringSubmatch(u.Path); m != nil {
+			if !seen[m[2]] {
+				seen[m[2]] = true
+				shows = append(shows, Show{
+					ID:         m[2],
+					URL:        u.String(),
+					Storefront: m[1],
+					Position:   len(shows) + 1,
+				})
+			}
+			continue
+		}
+
+		if u.Host == base.Host && u.Path == base.Path && u.RawQuery != base.RawQuery {
+			pages = append(pages, u.String())
+		}
+	}
+
+	return shows, pages
+}
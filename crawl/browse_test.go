@@ -0,0 +1,108 @@
+package crawl_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/deepilla/itunes/crawl"
+	"github.com/deepilla/itunes/itunestest"
+)
+
+// browsePages maps the letter query of a genre page to its
+// content.
+var browsePages = map[string]string{
+	"": `<html><body>
+<a href="/us/podcast/serial/id917918570">Serial</a>
+<a href="/us/podcast/serial/id917918570#reviews">Serial reviews</a>
+<a href="%[1]s/us/podcast/s-town/id1212558767?mt=2">S-Town</a>
+<a href="/us/genre/podcasts-arts/id1301?letter=B">B</a>
+<a href="/us/genre/podcasts-comedy/id1303">Comedy</a>
+</body></html>`,
+	"B": `<html><body>
+<a href="/us/podcast/bbc/id1">BBC</a>
+<a href="/us/genre/podcasts-arts/id1301?letter=B&amp;page=2">Next</a>
+</body></html>`,
+	"B2": `<html><body>
+<a href="/us/podcast/bugle/id2">The Bugle</a>
+<a href="/us/genre/podcasts-arts/id1301?letter=B">Back</a>
+</body></html>`,
+}
+
+func TestBrowse(t *testing.T) {
+
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+
+		if strings.HasPrefix(r.URL.Path, "/us/genre/podcasts-arts/id1301") {
+			key := r.URL.Query().Get("letter") + r.URL.Query().Get("page")
+			page, ok := browsePages[key]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprintf(w, page, ts.URL)
+			return
+		}
+
+		id := r.URL.Path[strings.LastIndex(r.URL.Path, "/id")+3:]
+		w.Header().Set("Content-Type", "text/html")
+		w.Write(itunestest.Page("", "https://example.com/feeds/"+id))
+	})
+
+	data := map[string]struct {
+		FollowPages bool
+		MaxPages    int
+		IDs         string
+	}{
+		"first page": {
+			IDs: "917918570,1212558767",
+		},
+		"all pages": {
+			FollowPages: true,
+			IDs:         "917918570,1212558767,1,2",
+		},
+		"two pages": {
+			FollowPages: true,
+			MaxPages:    2,
+			IDs:         "917918570,1212558767,1",
+		},
+	}
+
+	for name, test := range data {
+
+		c := &crawl.Crawler{
+			Sources: []crawl.Source{
+				&crawl.Browse{
+					URLs:        []string{ts.URL + "/us/genre/podcasts-arts/id1301"},
+					FollowPages: test.FollowPages,
+					MaxPages:    test.MaxPages,
+				},
+			},
+		}
+
+		var ids []string
+		err := c.Crawl(crawl.SinkFunc(func(show crawl.Show) error {
+			if show.Genre != "1301" || show.Storefront != "us" {
+				t.Errorf("%s: show %s: expected us/1301, got %s/%s", name, show.ID, show.Storefront, show.Genre)
+			}
+			if feed := "https://example.com/feeds/" + show.ID; show.Feed != feed {
+				t.Errorf("%s: show %s: expected feed %q, got %q (error %v)", name, show.ID, feed, show.Feed, show.Err)
+			}
+			ids = append(ids, show.ID)
+			return nil
+		}))
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		if got := strings.Join(ids, ","); got != test.IDs {
+			t.Errorf("%s: expected shows %s, got %s", name, test.IDs, got)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package crawl
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/deepilla/itunes"
+)
+
+const (
+	// podcastsGenre is the iTunes genre ID for Podcasts. Its
+	// chart is the overall top podcasts chart.
+	podcastsGenre = "26"
+
+	genresURL = "https://itunes.apple.com/WebObjects/MZStoreServices.woa/ws/genres?id=" + podcastsGenre
+	chartURL  = "https://itunes.apple.com/%s/rss/toppodcasts/limit=%d/genre=%s/json"
+
+	defaultLimit = 200
+)
+
+// Charts is a Source that walks the iTunes top podcast charts
+// for every genre in a list of storefronts. The key for each
+// chart is storefront/genre, e.g. us/1303.
+type Charts struct {
+	// Storefronts are the two-letter country codes of the
+	// stores to crawl. If empty, only the US store is crawled.
+	Storefronts []string
+
+	// Limit is the maximum number of shows to read from each
+	// chart. If zero, it defaults to 200, the most that iTunes
+	// allows.
+	Limit int
+}
+
+// Lists implements Source.
+func (c *Charts) Lists(client itunes.Client, fn func(key string, shows []Show) error) error {
+
+	genres, err := genres(client)
+	if err != nil {
+		return fmt.Errorf("genres: %w", err)
+	}
+
+	for _, sf := range c.storefronts() {
+		for _, genre := range genres {
+
+			key := sf + "/" + genre
+
+			shows, err := c.chart(client, sf, genre)
+			if err != nil {
+				return fmt.Errorf("chart %s: %w", key, err)
+			}
+
+			if err := fn(key, shows); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *Charts) storefronts() []string {
+	if len(c.Storefronts) == 0 {
+		return []string{"us"}
+	}
+	return c.Storefronts
+}
+
+func (c *Charts) limit() int {
+	if c.Limit <= 0 {
+		return defaultLimit
+	}
+	return c.Limit
+}
+
+type genre struct {
+	ID        string           `json:"id"`
+	Subgenres map[string]genre `json:"subgenres"`
+}
+
+// genres returns the IDs of Podcasts and all of its subgenres.
+func genres(client itunes.Client) ([]string, error) {
+
+	b, err := get(client, genresURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var tree map[string]genre
+	if err := json.Unmarshal(b, &tree); err != nil {
+		return nil, err
+	}
+
+	root, ok := tree[podcastsGenre]
+	if !ok {
+		return nil, errors.New("no Podcasts genre")
+	}
+
+	var ids []string
+	var walk func(g genre)
+	walk = func(g genre) {
+		ids = append(ids, g.ID)
+		for _, sub := range g.Subgenres {
+			walk(sub)
+		}
+	}
+	walk(root)
+
+	// Crawl the overall chart first and the rest in a
+	// predictable order.
+	sort.Slice(ids[1:], func(i, j int) bool {
+		return ids[i+1] < ids[j+1]
+	})
+
+	return ids, nil
+}
+
+type label struct {
+	Label string `json:"label"`
+}
+
+type entry struct {
+	Name label `json:"im:name"`
+	ID   struct {
+		Label      string `json:"label"`
+		Attributes struct {
+			ID string `json:"im:id"`
+		} `json:"attributes"`
+	} `json:"id"`
+}
+
+// entries unmarshals a chart's entries. iTunes returns a single
+// entry as an object rather than an array.
+type entries []entry
+
+func (e *entries) UnmarshalJSON(b []byte) error {
+
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("{")) {
+		var one entry
+		if err := json.Unmarshal(b, &one); err != nil {
+			return err
+		}
+		*e = entries{one}
+		return nil
+	}
+
+	var many []entry
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*e = many
+
+	return nil
+}
+
+func (c *Charts) chart(client itunes.Client, storefront, genre string) ([]Show, error) {
+
+	b, err := get(client, fmt.Sprintf(chartURL, storefront, c.limit(), genre))
+	if err != nil {
+		return nil, err
+	}
+
+	var chart struct {
+		Feed struct {
+			Entry entries `json:"entry"`
+		} `json:"feed"`
+	}
+
+	if err := json.Unmarshal(b, &chart); err != nil {
+		return nil, err
+	}
+
+	shows := make([]Show, 0, len(chart.Feed.Entry))
+	for i, e := range chart.Feed.Entry {
+		if e.ID.Attributes.ID == "" || e.ID.Label == "" {
+			continue
+		}
+		shows = append(shows, Show{
+			ID:         e.ID.Attributes.ID,
+			Title:      e.Name.Label,
+			URL:        e.ID.Label,
+			Storefront: storefront,
+			Genre:      genre,
+			Position:   i + 1,
+		})
+	}
+
+	return shows, nil
+}
@@ -0,0 +1,248 @@
+// Package crawl builds a podcast directory by discovering shows,
+// e.g. from the iTunes top podcast charts, and resolving each
+// show's feed.
+package crawl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/deepilla/itunes"
+)
+
+// A Show is a podcast found by a crawl.
+type Show struct {
+	// ID is the show's iTunes ID.
+	ID string
+
+	// Title is the show's title in the chart.
+	Title string
+
+	// URL is the show's iTunes page.
+	URL string
+
+	// Storefront and Genre describe where the show was found,
+	// if known. Position is the show's 1-based position in the
+	// list it was found in, e.g. a chart.
+	Storefront string
+	Genre      string
+	Position   int
+
+	// Feed is the show's RSS feed. If the feed couldn't be
+	// resolved, Err is the reason.
+	Feed string
+	Err  error
+}
+
+// A Sink receives shows as they are crawled.
+type Sink interface {
+	Put(show Show) error
+}
+
+// SinkFunc adapts an ordinary function to a Sink.
+type SinkFunc func(show Show) error
+
+// Put calls f(show).
+func (f SinkFunc) Put(show Show) error {
+	return f(show)
+}
+
+// A Source discovers shows for a Crawler.
+type Source interface {
+	// Lists calls fn with each list of shows that the source
+	// finds, stopping at the first error. The key uniquely
+	// identifies the list, e.g. a chart or a sitemap, so that
+	// an interrupted crawl can resume.
+	Lists(client itunes.Client, fn func(key string, shows []Show) error) error
+}
+
+// A Crawler resolves the feeds of the shows found by its
+// Sources. By default, it walks the iTunes top podcast charts
+// for every genre in a list of storefronts. The zero value is
+// ready to use.
+type Crawler struct {
+	// Client executes the HTTP requests. If nil, the default
+	// HTTP client is used.
+	Client itunes.Client
+
+	// Sources discover the shows to crawl. If empty, the
+	// crawler uses Charts with the Storefronts and Limit below.
+	Sources []Source
+
+	// Storefronts are the two-letter country codes of the
+	// stores to crawl. If empty, only the US store is crawled.
+	Storefronts []string
+
+	// Limit is the maximum number of shows to read from each
+	// chart. If zero, it defaults to 200, the most that iTunes
+	// allows.
+	Limit int
+
+	// Retries is the number of times to retry resolving a
+	// feed after a temporary failure.
+	Retries int
+
+	// Interval is the minimum time between requests to the
+	// same host.
+	Interval time.Duration
+
+	// Jitter, if set, adds a random delay of up to Jitter to
+	// each Interval so that requests aren't evenly spaced.
+	Jitter time.Duration
+
+	// Clock, if set, provides the time for spacing out requests
+	// and waits out the Intervals and retry delays. If nil,
+	// itunes.SystemClock is used.
+	Clock itunes.Clock
+
+	// CheckRobots enables checking requests against each host's
+	// robots.txt. Disallowed requests fail with ErrDisallowed.
+	CheckRobots bool
+
+	// StateFile, if set, is where the crawl's State is saved
+	// after each show. If the file exists when Crawl is called,
+	// the crawl resumes from the saved State.
+	StateFile string
+}
+
+// Crawl sends each show found by the Sources to the sink. A
+// show that is found more than once is only resolved and sent
+// the first time. Crawl stops at the first error discovering
+// shows or writing to the sink. Errors resolving feeds are
+// reported in the Show instead.
+func (c *Crawler) Crawl(sink Sink) (err error) {
+
+	st := newState()
+	if c.StateFile != "" {
+		st, err = LoadState(c.StateFile)
+		if err != nil {
+			return fmt.Errorf("state: %s", err)
+		}
+		defer func() {
+			if e := st.Save(c.StateFile); e != nil && err == nil {
+				err = fmt.Errorf("state: %s", e)
+			}
+		}()
+	}
+
+	client := newPoliteClient(c.Client, c.Clock, c.Interval, c.Jitter, c.CheckRobots)
+
+	for _, src := range c.sources() {
+
+		err := src.Lists(client, func(key string, shows []Show) error {
+
+			var todo []Show
+			queued := map[string]bool{}
+			for _, show := range shows {
+				if show.Position > st.Lists[key] && !st.Seen[show.ID] && !queued[show.ID] {
+					queued[show.ID] = true
+					todo = append(todo, show)
+				}
+			}
+
+			if err := c.resolve(client, key, todo, sink, st); err != nil {
+				return err
+			}
+
+			if n := len(shows); n > 0 && shows[n-1].Position > st.Lists[key] {
+				st.Lists[key] = shows[n-1].Position
+			}
+
+			if c.StateFile != "" {
+				if err := st.Save(c.StateFile); err != nil {
+					return fmt.Errorf("state: %s", err)
+				}
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Crawler) sources() []Source {
+
+	if len(c.Sources) > 0 {
+		return c.Sources
+	}
+
+	return []Source{
+		&Charts{
+			Storefronts: c.Storefronts,
+			Limit:       c.Limit,
+		},
+	}
+}
+
+func (c *Crawler) resolve(client itunes.Client, key string, shows []Show, sink Sink, st *State) error {
+
+	b := &itunes.Batch{
+		Client:  client,
+		Retries: c.Retries,
+		Clock:   c.Clock,
+	}
+
+	// Shows are resolved one at a time so that each reaches
+	// the sink as soon as it's done, rather than after the
+	// whole list.
+	for _, show := range shows {
+
+		res := b.ToRSS([]string{show.URL})[0]
+		show.Feed = res.Feed
+		show.Err = res.Err
+
+		if err := sink.Put(show); err != nil {
+			return err
+		}
+
+		st.Seen[show.ID] = true
+		if show.Err != nil {
+			st.Failures[show.ID] = show.Err.Error()
+		}
+		st.Lists[key] = show.Position
+
+		if c.StateFile != "" {
+			if err := st.Save(c.StateFile); err != nil {
+				return fmt.Errorf("state: %s", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func get(client itunes.Client, url string) ([]byte, error) {
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer closeBody(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// closeBody reads any remainder of a response body, up to a
+// limit, and closes it so that the connection can be reused.
+func closeBody(body io.ReadCloser) {
+	io.CopyN(ioutil.Discard, body, 64<<10)
+	body.Close()
+}
@@ -0,0 +1,549 @@
+package crawl_test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/deepilla/itunes"
+	"github.com/deepilla/itunes/crawl"
+	"github.com/deepilla/itunes/itunestest"
+)
+
+const genres = `{"26":{"name":"Podcasts","id":"26","subgenres":{
+	"1301":{"name":"Arts","id":"1301"},
+	"1303":{"name":"Comedy","id":"1303"}
+}}}`
+
+// charts maps storefront/genre to the IDs in the chart.
+var charts = map[string][]string{
+	"us/26":   {"1", "2", "3"},
+	"us/1301": {"4"},
+	"us/1303": {"2", "5"},
+	"gb/26":   {"6", "1"},
+	"gb/1301": {},
+	"gb/1303": {"7"},
+}
+
+var (
+	reChart = regexp.MustCompile(`^/([a-z]{2})/rss/toppodcasts/limit=\d+/genre=(\d+)/json$`)
+	rePage  = regexp.MustCompile(`/id(\d+)$`)
+)
+
+func chartJSON(ids []string) string {
+
+	var entries []string
+	for _, id := range ids {
+		entries = append(entries, fmt.Sprintf(`{"im:name":{"label":"Show %s"},"id":{"label":"https://itunes.apple.com/us/podcast/show-%s/id%s?mt=2","attributes":{"im:id":"%s"}}}`, id, id, id, id))
+	}
+
+	switch len(entries) {
+	case 0:
+		return `{"feed":{}}`
+	case 1:
+		// iTunes returns a single entry as an object.
+		return `{"feed":{"entry":` + entries[0] + `}}`
+	default:
+		return `{"feed":{"entry":[` + strings.Join(entries, ",") + `]}}`
+	}
+}
+
+func newServer(t *testing.T) (*httptest.Server, itunes.Client) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.URL.Path == "/WebObjects/MZStoreServices.woa/ws/genres" {
+			fmt.Fprint(w, genres)
+			return
+		}
+
+		if m := reChart.FindStringSubmatch(r.URL.Path); m != nil {
+			ids, ok := charts[m[1]+"/"+m[2]]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprint(w, chartJSON(ids))
+			return
+		}
+
+		if m := rePage.FindStringSubmatch(r.URL.Path); m != nil {
+			if m[1] == "5" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html")
+			w.Write(itunestest.Page("Show "+m[1], "https://example.com/feeds/"+m[1]))
+			return
+		}
+
+		http.NotFound(w, r)
+	}))
+
+	base, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := clientFunc(func(req *http.Request) (*http.Response, error) {
+		req.URL.Scheme = base.Scheme
+		req.URL.Host = base.Host
+		return http.DefaultClient.Do(req)
+	})
+
+	return ts, client
+}
+
+func TestCrawl(t *testing.T) {
+
+	ts, client := newServer(t)
+	defer ts.Close()
+
+	c := &crawl.Crawler{
+		Client:      client,
+		Storefronts: []string{"us", "gb"},
+	}
+
+	var shows []crawl.Show
+	err := c.Crawl(crawl.SinkFunc(func(show crawl.Show) error {
+		shows = append(shows, show)
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []struct {
+		ID       string
+		Chart    string
+		Position int
+		Error    bool
+	}{
+		{"1", "us/26", 1, false},
+		{"2", "us/26", 2, false},
+		{"3", "us/26", 3, false},
+		{"4", "us/1301", 1, false},
+		{"5", "us/1303", 2, true},
+		{"6", "gb/26", 1, false},
+		{"7", "gb/1303", 1, false},
+	}
+
+	if len(shows) != len(exp) {
+		t.Fatalf("expected %d shows, got %d", len(exp), len(shows))
+	}
+
+	for i, show := range shows {
+
+		e := exp[i]
+
+		if show.ID != e.ID {
+			t.Errorf("show %d: expected ID %s, got %s", i, e.ID, show.ID)
+		}
+
+		if chart := show.Storefront + "/" + show.Genre; chart != e.Chart || show.Position != e.Position {
+			t.Errorf("show %d: expected %s #%d, got %s #%d", i, e.Chart, e.Position, chart, show.Position)
+		}
+
+		if e.Error {
+			if show.Err == nil {
+				t.Errorf("show %d: expected an error", i)
+			}
+			continue
+		}
+
+		if show.Err != nil {
+			t.Errorf("show %d: unexpected error %s", i, show.Err)
+		}
+
+		if feed := "https://example.com/feeds/" + e.ID; show.Feed != feed {
+			t.Errorf("show %d: expected feed %q, got %q", i, feed, show.Feed)
+		}
+	}
+}
+
+func TestCrawlSinkError(t *testing.T) {
+
+	ts, client := newServer(t)
+	defer ts.Close()
+
+	c := &crawl.Crawler{
+		Client: client,
+	}
+
+	errFull := errors.New("sink is full")
+
+	n := 0
+	err := c.Crawl(crawl.SinkFunc(func(show crawl.Show) error {
+		n++
+		if n == 2 {
+			return errFull
+		}
+		return nil
+	}))
+
+	if err != errFull {
+		t.Errorf("expected error %v, got %v", errFull, err)
+	}
+
+	if n != 2 {
+		t.Errorf("expected 2 calls to the sink, got %d", n)
+	}
+}
+
+func TestCrawlStreams(t *testing.T) {
+
+	ts, client := newServer(t)
+	defer ts.Close()
+
+	var events []string
+
+	c := &crawl.Crawler{
+		Client: clientFunc(func(req *http.Request) (*http.Response, error) {
+			if m := rePage.FindStringSubmatch(req.URL.Path); m != nil {
+				events = append(events, "get "+m[1])
+			}
+			return client.Do(req)
+		}),
+	}
+
+	err := c.Crawl(crawl.SinkFunc(func(show crawl.Show) error {
+		events = append(events, "put "+show.ID)
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each show is sent to the sink before the next one is
+	// resolved.
+	exp := "get 1,put 1,get 2,put 2,get 3,put 3,get 4,put 4,get 5,put 5"
+	if got := strings.Join(events, ","); got != exp {
+		t.Errorf("expected events %s, got %s", exp, got)
+	}
+}
+
+func TestCrawlChartError(t *testing.T) {
+
+	ts, client := newServer(t)
+	defer ts.Close()
+
+	c := &crawl.Crawler{
+		Client:      client,
+		Storefronts: []string{"fr"},
+	}
+
+	err := c.Crawl(crawl.SinkFunc(func(crawl.Show) error {
+		return nil
+	}))
+
+	if err == nil {
+		t.Error("expected an error for a missing chart")
+	}
+}
+
+func TestCrawlResume(t *testing.T) {
+
+	ts, client := newServer(t)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "crawl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &crawl.Crawler{
+		Client:      client,
+		Storefronts: []string{"us", "gb"},
+		StateFile:   filepath.Join(dir, "state.json"),
+	}
+
+	errStop := errors.New("interrupted")
+
+	var ids []string
+	n := 0
+	err = c.Crawl(crawl.SinkFunc(func(show crawl.Show) error {
+		n++
+		if n == 4 {
+			return errStop
+		}
+		ids = append(ids, show.ID)
+		return nil
+	}))
+	if err != errStop {
+		t.Fatalf("expected error %v, got %v", errStop, err)
+	}
+
+	st, err := crawl.LoadState(c.StateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(st.Seen) != 3 || st.Lists["us/26"] != 3 {
+		t.Errorf("expected 3 shows seen up to us/26 #3, got %d seen, us/26 #%d", len(st.Seen), st.Lists["us/26"])
+	}
+
+	err = c.Crawl(crawl.SinkFunc(func(show crawl.Show) error {
+		ids = append(ids, show.ID)
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, exp := strings.Join(ids, ","), "1,2,3,4,5,6,7"; got != exp {
+		t.Errorf("expected shows %s, got %s", exp, got)
+	}
+
+	st, err = crawl.LoadState(c.StateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := st.Failures["5"]; !ok || len(st.Failures) != 1 {
+		t.Errorf("expected a failure for show 5 only, got %v", st.Failures)
+	}
+
+	// A completed crawl has nothing left to do.
+	err = c.Crawl(crawl.SinkFunc(func(show crawl.Show) error {
+		t.Errorf("unexpected show %s", show.ID)
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCrawlStateSavedPerShow(t *testing.T) {
+
+	ts, client := newServer(t)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "crawl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &crawl.Crawler{
+		Client:    client,
+		StateFile: filepath.Join(dir, "state.json"),
+	}
+
+	// Read the State file mid-crawl, as if the process had been
+	// killed before Crawl could return.
+	n := 0
+	err = c.Crawl(crawl.SinkFunc(func(show crawl.Show) error {
+
+		n++
+		if n != 3 {
+			return nil
+		}
+
+		st, err := crawl.LoadState(c.StateFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(st.Seen) != 2 || st.Lists["us/26"] != 2 {
+			t.Errorf("expected 2 shows seen up to us/26 #2, got %d seen, us/26 #%d", len(st.Seen), st.Lists["us/26"])
+		}
+
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+type clientFunc func(req *http.Request) (*http.Response, error)
+
+func (f clientFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestCrawlInterval(t *testing.T) {
+
+	ts, client := newServer(t)
+	defer ts.Close()
+
+	const (
+		interval = 20 * time.Second
+		jitter   = 5 * time.Second
+	)
+
+	clock := &fakeClock{now: time.Date(2017, 3, 28, 10, 0, 0, 0, time.UTC)}
+
+	var times []time.Time
+	c := &crawl.Crawler{
+		Client: clientFunc(func(req *http.Request) (*http.Response, error) {
+			times = append(times, clock.Now())
+			return client.Do(req)
+		}),
+		Interval: interval,
+		Jitter:   jitter,
+		Clock:    clock,
+	}
+
+	err := c.Crawl(crawl.SinkFunc(func(crawl.Show) error {
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(times) < 2 {
+		t.Fatalf("expected several requests, got %d", len(times))
+	}
+
+	// Every request goes to the same host, and no time passes
+	// between requests apart from the waits.
+	for i := 1; i < len(times); i++ {
+		if d := times[i].Sub(times[i-1]); d < interval || d >= interval+jitter {
+			t.Errorf("request %d: expected a gap of %s to %s, got %s", i, interval, interval+jitter, d)
+		}
+	}
+}
+
+// A fakeClock only moves when it sleeps.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestCrawlRobots(t *testing.T) {
+
+	ts, client := newServer(t)
+	defer ts.Close()
+
+	robots := `# Keep out of show 3
+User-agent: *
+Disallow: /us/podcast/show-3/
+
+User-agent: iTunes
+Disallow: /us/podcast/show-2/
+Allow: /us/podcast/show-2/id2
+`
+
+	c := &crawl.Crawler{
+		Client: clientFunc(func(req *http.Request) (*http.Response, error) {
+			if req.URL.Path == "/robots.txt" {
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       ioutil.NopCloser(strings.NewReader(robots)),
+					Header:     http.Header{},
+				}, nil
+			}
+			return client.Do(req)
+		}),
+		CheckRobots: true,
+	}
+
+	errs := map[string]error{}
+	err := c.Crawl(crawl.SinkFunc(func(show crawl.Show) error {
+		errs[show.ID] = show.Err
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The itunes package identifies itself as iTunes, so the
+	// iTunes group applies and show 3 is allowed.
+	for _, id := range []string{"1", "2", "3", "4"} {
+		if errs[id] != nil {
+			t.Errorf("show %s: unexpected error %s", id, errs[id])
+		}
+	}
+
+	// Robots rules are checked for chart requests too.
+	c.Client = clientFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path == "/robots.txt" {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(strings.NewReader("User-agent: *\nDisallow: /us/rss/\n")),
+				Header:     http.Header{},
+			}, nil
+		}
+		return client.Do(req)
+	})
+
+	err = c.Crawl(crawl.SinkFunc(func(crawl.Show) error {
+		return nil
+	}))
+	if !errors.Is(err, crawl.ErrDisallowed) {
+		t.Errorf("expected ErrDisallowed, got %v", err)
+	}
+}
+
+func TestCrawlRobotsWildcards(t *testing.T) {
+
+	ts, client := newServer(t)
+	defer ts.Close()
+
+	robots := `User-agent: *
+Disallow: /*/podcast/show-1/
+Disallow: /us/podcast/*/id2$
+Disallow: /us/podcast/show-3/id$
+Disallow: /us/podcast/show-4/
+Allow: /us/podcast/show-4/*4$
+`
+
+	c := &crawl.Crawler{
+		Client: clientFunc(func(req *http.Request) (*http.Response, error) {
+			if req.URL.Path == "/robots.txt" {
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       ioutil.NopCloser(strings.NewReader(robots)),
+					Header:     http.Header{},
+				}, nil
+			}
+			return client.Do(req)
+		}),
+		CheckRobots: true,
+	}
+
+	errs := map[string]error{}
+	err := c.Crawl(crawl.SinkFunc(func(show crawl.Show) error {
+		errs[show.ID] = show.Err
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string]bool{
+		"1": true,  // * matches the storefront
+		"2": true,  // * matches the slug, $ the end of the path
+		"3": false, // $ stops the rule matching id3
+		"4": false, // the longer Allow wins
+	}
+
+	for id, disallowed := range exp {
+		if got := errors.Is(errs[id], crawl.ErrDisallowed); got != disallowed {
+			t.Errorf("show %s: expected disallowed %t, got error %v", id, disallowed, errs[id])
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package crawl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deepilla/itunes"
+)
+
+// ErrDisallowed is returned for requests to URLs that are
+// disallowed by the host's robots.txt.
+var ErrDisallowed = errors.New("disallowed by robots.txt")
+
+// A politeClient spaces out requests to each host and, if
+// robots is set, checks them against the host's robots.txt.
+type politeClient struct {
+	client   itunes.Client
+	clock    itunes.Clock
+	interval time.Duration
+	jitter   time.Duration
+	robots   bool
+
+	mu          sync.Mutex
+	next        map[string]time.Time
+	robotsFiles map[string]robotsFile
+	random      *rand.Rand
+}
+
+func newPoliteClient(client itunes.Client, clock itunes.Clock, interval, jitter time.Duration, robots bool) *politeClient {
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	if clock == nil {
+		clock = itunes.SystemClock
+	}
+
+	return &politeClient{
+		client:      client,
+		clock:       clock,
+		interval:    interval,
+		jitter:      jitter,
+		robots:      robots,
+		next:        map[string]time.Time{},
+		robotsFiles: map[string]robotsFile{},
+		random:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (c *politeClient) Do(req *http.Request) (*http.Response, error) {
+
+	if c.robots {
+		f, err := c.robotsFile(req)
+		if err != nil {
+			return nil, err
+		}
+		if !f.rules(req.Header.Get("User-Agent")).allowed(req.URL.EscapedPath()) {
+			return nil, fmt.Errorf("%s: %w", req.URL, ErrDisallowed)
+		}
+	}
+
+	c.wait(req.URL.Host)
+
+	return c.client.Do(req)
+}
+
+// wait blocks until the next request to host is allowed.
+func (c *politeClient) wait(host string) {
+	for {
+		c.mu.Lock()
+
+		now := c.clock.Now()
+		next := c.next[host]
+		if !now.Before(next) {
+			delay := c.interval
+			if c.jitter > 0 {
+				delay += time.Duration(c.random.Int63n(int64(c.jitter)))
+			}
+			c.next[host] = now.Add(delay)
+			c.mu.Unlock()
+			return
+		}
+
+		c.mu.Unlock()
+		c.clock.Sleep(next.Sub(now))
+	}
+}
+
+func (c *politeClient) robotsFile(req *http.Request) (robotsFile, error) {
+
+	host := req.URL.Host
+
+	c.mu.Lock()
+	f, ok := c.robotsFiles[host]
+	c.mu.Unlock()
+
+	if ok {
+		return f, nil
+	}
+
+	u := *req.URL
+	u.Path = "/robots.txt"
+	u.RawPath = ""
+	u.RawQuery = ""
+	u.Fragment = ""
+
+	r, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c.wait(host)
+
+	resp, err := c.client.Do(r)
+	if err != nil {
+		return nil, fmt.Errorf("robots.txt: %s", err)
+	}
+	defer closeBody(resp.Body)
+
+	// A missing robots.txt allows everything.
+	f = robotsFile{}
+	if resp.StatusCode == http.StatusOK {
+		f = parseRobots(resp.Body)
+	}
+
+	c.mu.Lock()
+	c.robotsFiles[host] = f
+	c.mu.Unlock()
+
+	return f, nil
+}
+
+// robotsRules are the Allow and Disallow rules that apply to
+// a User Agent. Rules are path prefixes in which, as in RFC
+// 9309, * matches any characters and a trailing $ matches the
+// end of the path, e.g. /*/podcast/ or /*.xml$.
+type robotsRules struct {
+	allow    []string
+	disallow []string
+}
+
+// allowed reports whether path is allowed. The longest matching
+// rule wins, and Allow wins a tie.
+func (r *robotsRules) allowed(path string) bool {
+
+	longest := func(rules []string) int {
+		n := -1
+		for _, rule := range rules {
+			if matchRule(rule, path) && len(rule) > n {
+				n = len(rule)
+			}
+		}
+		return n
+	}
+
+	d := longest(r.disallow)
+	return d < 0 || longest(r.allow) >= d
+}
+
+// matchRule reports whether path matches a robots.txt rule.
+func matchRule(rule, path string) bool {
+
+	anchored := strings.HasSuffix(rule, "$")
+	if anchored {
+		rule = rule[:len(rule)-1]
+	}
+
+	parts := strings.Split(rule, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	path = path[len(parts[0]):]
+
+	if len(parts) == 1 {
+		return !anchored || path == ""
+	}
+
+	// Matching each part at its first occurrence leaves the
+	// most room for the parts after it.
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(path, part)
+		if i < 0 {
+			return false
+		}
+		path = path[i+len(part):]
+	}
+
+	last := parts[len(parts)-1]
+	if anchored {
+		return strings.HasSuffix(path, last)
+	}
+	return strings.Contains(path, last)
+}
+
+// A robotsFile maps the lowercase User-agent names in a
+// robots.txt to their rules.
+type robotsFile map[string]*robotsRules
+
+// rules returns the rules for userAgent. It uses the group
+// whose name best matches, falling back to the * group.
+func (f robotsFile) rules(userAgent string) *robotsRules {
+
+	ua := strings.ToLower(userAgent)
+
+	var best *robotsRules
+	bestLen := 0
+	for name, g := range f {
+		if name != "*" && strings.Contains(ua, name) && len(name) > bestLen {
+			best, bestLen = g, len(name)
+		}
+	}
+
+	if best == nil {
+		best = f["*"]
+	}
+	if best == nil {
+		best = &robotsRules{}
+	}
+
+	return best
+}
+
+func parseRobots(rd io.Reader) robotsFile {
+
+	f := robotsFile{}
+	var current []*robotsRules
+	inRules := false
+
+	scanner := bufio.NewScanner(rd)
+	for scanner.Scan() {
+
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:i]))
+		val := strings.TrimSpace(line[i+1:])
+
+		switch key {
+		case "user-agent":
+			// Consecutive User-agent lines share a group.
+			if inRules {
+				current = nil
+				inRules = false
+			}
+			name := strings.ToLower(val)
+			g, ok := f[name]
+			if !ok {
+				g = &robotsRules{}
+				f[name] = g
+			}
+			current = append(current, g)
+
+		case "allow", "disallow":
+			inRules = true
+			if val == "" {
+				// An empty Disallow allows everything.
+				continue
+			}
+			for _, g := range current {
+				if key == "allow" {
+					g.allow = append(g.allow, val)
+				} else {
+					g.disallow = append(g.disallow, val)
+				}
+			}
+		}
+	}
+
+	return f
+}
@@ -0,0 +1,141 @@
+package crawl
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/deepilla/itunes"
+)
+
+// DefaultSitemapIndex is the index of Apple Podcasts' sitemaps
+// for shows.
+const DefaultSitemapIndex = "https://podcasts.apple.com/sitemaps_podcasts_index_podcast_1.xml"
+
+// Sitemaps is a Source that finds shows in Apple Podcasts'
+// public sitemaps. Sitemaps include long-tail shows that never
+// appear in the charts. The key for each list of shows is the
+// URL of the sitemap.
+type Sitemaps struct {
+	// IndexURL is the sitemap index to read. It may also be a
+	// single sitemap. If empty, DefaultSitemapIndex is used.
+	IndexURL string
+
+	// Storefronts, if set, restricts the shows to those with
+	// URLs in the given stores, e.g. "us" or "gb".
+	Storefronts []string
+}
+
+type sitemapDoc struct {
+	XMLName  xml.Name
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+	URLs []struct {
+		Loc string `xml:"loc"`
+	} `xml:"url"`
+}
+
+// Lists implements Source.
+func (s *Sitemaps) Lists(client itunes.Client, fn func(key string, shows []Show) error) error {
+
+	index := s.IndexURL
+	if index == "" {
+		index = DefaultSitemapIndex
+	}
+
+	doc, err := getSitemap(client, index)
+	if err != nil {
+		return fmt.Errorf("sitemap %s: %w", index, err)
+	}
+
+	if doc.XMLName.Local == "urlset" {
+		return fn(index, s.shows(doc))
+	}
+
+	for _, sm := range doc.Sitemaps {
+
+		loc := strings.TrimSpace(sm.Loc)
+
+		doc, err := getSitemap(client, loc)
+		if err != nil {
+			return fmt.Errorf("sitemap %s: %w", loc, err)
+		}
+
+		if err := fn(loc, s.shows(doc)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Matches a show's URL path, e.g. /us/podcast/serial/id917918570.
+var reShowPath = regexp.MustCompile(`^/([a-z]{2})/podcast/(?:[^/]+/)?id(\d+)$`)
+
+func (s *Sitemaps) shows(doc *sitemapDoc) []Show {
+
+	want := map[string]bool{}
+	for _, sf := range s.Storefronts {
+		want[strings.ToLower(sf)] = true
+	}
+
+	var shows []Show
+	for i, loc := range doc.URLs {
+
+		u, err := url.Parse(strings.TrimSpace(loc.Loc))
+		if err != nil {
+			continue
+		}
+
+		m := reShowPath.FindStringSubmatch(u.Path)
+		if m == nil {
+			continue
+		}
+
+		if len(want) > 0 && !want[m[1]] {
+			continue
+		}
+
+		shows = append(shows, Show{
+			ID:         m[2],
+			URL:        u.String(),
+			Storefront: m[1],
+			Position:   i + 1,
+		})
+	}
+
+	return shows
+}
+
+func getSitemap(client itunes.Client, u string) (*sitemapDoc, error) {
+
+	b, err := get(client, u)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sitemaps are often gzipped.
+	if bytes.HasPrefix(b, []byte{0x1f, 0x8b}) {
+		z, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		b, err = ioutil.ReadAll(z)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var doc sitemapDoc
+	if err := xml.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+
+	return &doc, nil
+}
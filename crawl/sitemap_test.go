@@ -0,0 +1,107 @@
+package crawl_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/deepilla/itunes/crawl"
+	"github.com/deepilla/itunes/itunestest"
+)
+
+const sitemapIndex = `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+<sitemap><loc>%[1]s/sitemap-1.xml</loc></sitemap>
+<sitemap><loc>%[1]s/sitemap-2.xml.gz</loc></sitemap>
+</sitemapindex>`
+
+const sitemap1 = `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+<url><loc>%[1]s/us/podcast/serial/id917918570</loc></url>
+<url><loc>%[1]s/gb/podcast/serial/id917918570</loc></url>
+<url><loc>%[1]s/us/genre/podcasts-arts/id1301</loc></url>
+<url><loc>%[1]s/gb/podcast/no-such-thing-as-a-fish/id840986946</loc></url>
+</urlset>`
+
+const sitemap2 = `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+<url><loc>%[1]s/us/podcast/s-town/id1212558767</loc></url>
+</urlset>`
+
+func TestSitemaps(t *testing.T) {
+
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	mux.HandleFunc("/index.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, sitemapIndex, ts.URL)
+	})
+	mux.HandleFunc("/sitemap-1.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, sitemap1, ts.URL)
+	})
+	mux.HandleFunc("/sitemap-2.xml.gz", func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		z := gzip.NewWriter(&buf)
+		fmt.Fprintf(z, sitemap2, ts.URL)
+		z.Close()
+		w.Write(buf.Bytes())
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Path[strings.LastIndex(r.URL.Path, "/id")+3:]
+		w.Header().Set("Content-Type", "text/html")
+		w.Write(itunestest.Page("", "https://example.com/feeds/"+id))
+	})
+
+	data := map[string]struct {
+		Storefronts []string
+		IDs         string
+	}{
+		"all stores": {
+			IDs: "917918570,840986946,1212558767",
+		},
+		"us only": {
+			Storefronts: []string{"us"},
+			IDs:         "917918570,1212558767",
+		},
+		"gb only": {
+			Storefronts: []string{"GB"},
+			IDs:         "917918570,840986946",
+		},
+	}
+
+	for name, test := range data {
+
+		c := &crawl.Crawler{
+			Sources: []crawl.Source{
+				&crawl.Sitemaps{
+					IndexURL:    ts.URL + "/index.xml",
+					Storefronts: test.Storefronts,
+				},
+			},
+		}
+
+		var ids []string
+		err := c.Crawl(crawl.SinkFunc(func(show crawl.Show) error {
+			if show.Err != nil {
+				t.Errorf("%s: show %s: unexpected error %s", name, show.ID, show.Err)
+			}
+			if feed := "https://example.com/feeds/" + show.ID; show.Feed != feed {
+				t.Errorf("%s: show %s: expected feed %q, got %q", name, show.ID, feed, show.Feed)
+			}
+			ids = append(ids, show.ID)
+			return nil
+		}))
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		if got := strings.Join(ids, ","); got != test.IDs {
+			t.Errorf("%s: expected shows %s, got %s", name, test.IDs, got)
+		}
+	}
+}
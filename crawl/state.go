@@ -0,0 +1,81 @@
+package crawl
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+)
+
+// A State records the progress of a crawl so that it can be
+// resumed if interrupted.
+type State struct {
+	// Seen are the IDs of the shows sent to the sink.
+	Seen map[string]bool `json:"seen"`
+
+	// Lists maps the key of a list of shows, e.g. a chart, to
+	// the position of the last show processed in it.
+	Lists map[string]int `json:"lists"`
+
+	// Failures maps the IDs of shows whose feeds couldn't be
+	// resolved to the reason. Failed shows are not retried when
+	// a crawl resumes.
+	Failures map[string]string `json:"failures"`
+}
+
+func newState() *State {
+	return &State{
+		Seen:     map[string]bool{},
+		Lists:    map[string]int{},
+		Failures: map[string]string{},
+	}
+}
+
+// LoadState reads a State from a file. If the file doesn't
+// exist, LoadState returns an empty State.
+func LoadState(filename string) (*State, error) {
+
+	st := newState()
+
+	b, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, st); err != nil {
+		return nil, err
+	}
+
+	// Guard against files with missing fields.
+	if st.Seen == nil {
+		st.Seen = map[string]bool{}
+	}
+	if st.Lists == nil {
+		st.Lists = map[string]int{}
+	}
+	if st.Failures == nil {
+		st.Failures = map[string]string{}
+	}
+
+	return st, nil
+}
+
+// Save writes the State to a file. The file is replaced
+// atomically so that an interrupted Save doesn't lose the
+// previous State.
+func (st *State) Save(filename string) error {
+
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+
+	tmp := filename + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filename)
+}
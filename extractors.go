@@ -0,0 +1,127 @@
+package itunes
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"sync"
+)
+
+// An Outcome is what an Extractor finds in a response.
+type Outcome struct {
+	// Feed is the RSS feed, if the response contains one.
+	Feed string
+
+	// Next is the URL to fetch next if the response is a
+	// redirect of some kind, like a Goto plist.
+	Next string
+}
+
+// An Extractor handles a kind of response that the package
+// doesn't know about, e.g. a new format from Apple.
+type Extractor interface {
+	// CanHandle reports whether the Extractor handles a
+	// response with the given Content Type. peek holds up to
+	// the first 512 bytes of the response.
+	CanHandle(contentType string, peek []byte) bool
+
+	// Extract reads the response and returns its feed or the
+	// URL to fetch next.
+	Extract(r io.Reader) (Outcome, error)
+}
+
+var (
+	extractorsMu sync.RWMutex
+	extractors   []namedExtractor
+)
+
+type namedExtractor struct {
+	name string
+	Extractor
+}
+
+// RegisterExtractor adds an Extractor to those consulted by the
+// ToRSS functions and ToRSSReader. Registered Extractors are
+// asked, in the order they were registered, whether they handle
+// each response before the built-in strategies are attempted.
+// The first one that does is used, and its name is reported as
+// the strategy that found the feed. RegisterExtractor panics if
+// e is nil or name is empty or already registered. It is safe
+// to call while feeds are being resolved, but Extractors are
+// usually registered in an init function.
+func RegisterExtractor(name string, e Extractor) {
+
+	if e == nil || name == "" {
+		panic("itunes: RegisterExtractor needs a name and an Extractor")
+	}
+
+	extractorsMu.Lock()
+	defer extractorsMu.Unlock()
+
+	for _, x := range extractors {
+		if x.name == name {
+			panic("itunes: RegisterExtractor called twice for " + name)
+		}
+	}
+
+	extractors = append(extractors, namedExtractor{name, e})
+}
+
+var errEmptyOutcome = errors.New("no feed or next URL")
+
+// findExtractor returns the first registered Extractor that
+// handles r, or nil if there isn't one, along with a reader
+// that replaces r.
+func findExtractor(r io.Reader, contentType string) (*namedExtractor, io.Reader) {
+
+	extractorsMu.RLock()
+	registered := extractors
+	extractorsMu.RUnlock()
+
+	if len(registered) == 0 {
+		return nil, r
+	}
+
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+
+	// Peek returns an error if the body is shorter than 512
+	// bytes, which is fine.
+	peek, _ := br.Peek(512)
+
+	for i := range registered {
+		if registered[i].CanHandle(contentType, peek) {
+			return &registered[i], br
+		}
+	}
+
+	return nil, br
+}
+
+// extract runs the Extractor on r. Its failures are reported
+// as a *NoFeedError, like those of the built-in strategies.
+func (x *namedExtractor) extract(r io.Reader) (feed, next string, err error) {
+
+	out, err := x.Extract(r)
+	switch {
+	case err != nil:
+	case out.Feed != "":
+		feed, err = checkFeedURL(normalizeURL(out.Feed))
+	case out.Next == "":
+		err = errEmptyOutcome
+	}
+
+	if err != nil {
+		return "", "", &NoFeedError{
+			Errors: []*StrategyError{{Strategy: x.name, Err: err}},
+		}
+	}
+
+	if feed != "" {
+		return feed, "", nil
+	}
+
+	return "", out.Next, nil
+}
@@ -0,0 +1,180 @@
+package itunes_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/deepilla/itunes"
+	"github.com/deepilla/itunes/itunestest"
+)
+
+// A jsonExtractor handles a made-up JSON format that holds
+// either a feed or the next URL to fetch.
+type jsonExtractor struct{}
+
+const jsonMediaType = "application/vnd.example.podcast+json"
+
+func (jsonExtractor) CanHandle(contentType string, peek []byte) bool {
+	media, _, err := mime.ParseMediaType(contentType)
+	return err == nil && media == jsonMediaType
+}
+
+func (jsonExtractor) Extract(r io.Reader) (itunes.Outcome, error) {
+
+	var v struct {
+		Feed string `json:"feedUrl"`
+		Next string `json:"next"`
+	}
+
+	if err := json.NewDecoder(r).Decode(&v); err != nil {
+		return itunes.Outcome{}, err
+	}
+
+	return itunes.Outcome{Feed: v.Feed, Next: v.Next}, nil
+}
+
+var registerOnce sync.Once
+
+func registerJSONExtractor() {
+	registerOnce.Do(func() {
+		itunes.RegisterExtractor("example JSON", jsonExtractor{})
+	})
+}
+
+func TestRegisterExtractor(t *testing.T) {
+
+	registerJSONExtractor()
+
+	const feed = "http://feeds.serialpodcast.org/serialpodcast"
+
+	data := map[string]struct {
+		Body string
+		Feed string
+		Next string
+		Err  error
+	}{
+		"feed": {
+			Body: `{"feedUrl":"` + feed + `"}`,
+			Feed: feed,
+		},
+		"next": {
+			Body: `{"next":"https://itunes.apple.com/us/podcast/id1"}`,
+			Next: "https://itunes.apple.com/us/podcast/id1",
+		},
+		"invalid feed": {
+			Body: `{"feedUrl":"javascript:alert(1)"}`,
+			Err:  itunes.ErrInvalidFeedURL,
+		},
+		"empty": {
+			Body: `{}`,
+			Err:  itunes.ErrNoFeed,
+		},
+	}
+
+	for name, exp := range data {
+
+		feed, next, err := itunes.ToRSSReader(strings.NewReader(exp.Body), jsonMediaType+"; charset=utf-8")
+
+		if feed != exp.Feed || next != exp.Next {
+			t.Errorf("%s: expected feed %q and next %q, got %q and %q", name, exp.Feed, exp.Next, feed, next)
+		}
+
+		if exp.Err == nil && err != nil || exp.Err != nil && !errors.Is(err, exp.Err) {
+			t.Errorf("%s: expected error %s, got %s", name, formatError(exp.Err), formatError(err))
+		}
+
+		var e *itunes.StrategyError
+		if err != nil && (!errors.As(err, &e) || e.Strategy != "example JSON") {
+			t.Errorf("%s: expected a StrategyError from the extractor, got %s", name, formatError(err))
+		}
+	}
+
+	// Other responses are handled as before.
+	got, _, err := itunes.ToRSSReader(strings.NewReader(string(itunestest.Page("Serial", feed))), "text/html")
+	if err != nil || got != feed {
+		t.Errorf("expected the built-in strategies to find the feed, got %q (error %s)", got, formatError(err))
+	}
+}
+
+func TestRegisterExtractorChain(t *testing.T) {
+
+	registerJSONExtractor()
+
+	const feed = "http://feeds.serialpodcast.org/serialpodcast"
+
+	// A JSON redirect to a page.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/json" {
+			w.Header().Set("Content-Type", jsonMediaType)
+			w.Write([]byte(`{"next":"` + "http://" + r.Host + `/page"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write(itunestest.Page("Serial", feed))
+	}))
+	defer ts.Close()
+
+	b := &itunes.Batch{}
+	res := b.ToRSS([]string{ts.URL + "/json"})[0]
+
+	if res.Err != nil || res.Feed != feed {
+		t.Errorf("expected feed %q, got %q (error %s)", feed, res.Feed, formatError(res.Err))
+	}
+
+	if res.Requests != 2 {
+		t.Errorf("expected 2 requests, got %d", res.Requests)
+	}
+}
+
+// A sniffedExtractor handles a made-up XML format that is
+// sometimes served as text/plain.
+type sniffedExtractor struct{}
+
+func (sniffedExtractor) CanHandle(contentType string, peek []byte) bool {
+	media, _, err := mime.ParseMediaType(contentType)
+	return err == nil && media == "text/xml" && strings.Contains(string(peek), "<example-podcast ")
+}
+
+func (sniffedExtractor) Extract(r io.Reader) (itunes.Outcome, error) {
+	return itunes.Outcome{Feed: "http://feeds.serialpodcast.org/serialpodcast"}, nil
+}
+
+var registerSniffedOnce sync.Once
+
+func TestRegisterExtractorSniffed(t *testing.T) {
+
+	registerSniffedOnce.Do(func() {
+		itunes.RegisterExtractor("example XML", sniffedExtractor{})
+	})
+
+	const body = `<?xml version="1.0"?><example-podcast feed="http://feeds.serialpodcast.org/serialpodcast"/>`
+
+	o := itunes.Options{SniffPlainText: true}
+
+	// The extractor is asked about the sniffed Content Type,
+	// not the text/plain that the body was served as.
+	feed, _, err := o.ToRSSReader(strings.NewReader(body), "text/plain; charset=utf-8")
+	if err != nil || feed != "http://feeds.serialpodcast.org/serialpodcast" {
+		t.Errorf("expected the extractor to find the feed, got %q (error %s)", feed, formatError(err))
+	}
+}
+
+func TestRegisterExtractorPanics(t *testing.T) {
+
+	registerJSONExtractor()
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for a duplicate name")
+		}
+	}()
+
+	itunes.RegisterExtractor("example JSON", jsonExtractor{})
+}
@@ -4,13 +4,21 @@ package itunes
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"mime"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"regexp"
+	"sort"
+	"strings"
+	"sync"
 
 	"golang.org/x/net/html"
 )
@@ -18,12 +26,174 @@ import (
 const iTunesUA = "iTunes/10.1"
 const maxRedirects = 3
 
-// ErrNoFeed is returned by the ToRSS functions when they fail
-// to find an RSS feed in the given iTunes page. This usually
-// indicates an unsupported page type, such as a non-podcast
-// iTunes page or an iTunesU page.
+// ErrNoFeed indicates that the ToRSS functions failed to find
+// an RSS feed in the given iTunes page. This usually indicates
+// an unsupported page type, such as a non-podcast iTunes page
+// or an iTunesU page. The ToRSS functions report this condition
+// with a *NoFeedError, which matches ErrNoFeed via errors.Is.
 var ErrNoFeed = errors.New("no feed found")
 
+// ErrBlocked indicates that Apple refused a request because it
+// looked like it came from a bot, either with a 403 Forbidden
+// response or with a CAPTCHA page. Check for it with errors.Is.
+var ErrBlocked = errors.New("blocked by Apple")
+
+// ErrTooLarge is returned for pages larger than
+// Options.MaxPageSize.
+var ErrTooLarge = errors.New("page too large")
+
+// errCaptcha is returned for HTML pages that ask for a CAPTCHA
+// instead of showing a feed.
+var errCaptcha = fmt.Errorf("CAPTCHA page: %w", ErrBlocked)
+
+// A StrategyError records why an extraction strategy failed
+// to find an RSS feed.
+type StrategyError struct {
+	Strategy string
+	Err      error
+}
+
+func (e *StrategyError) Error() string {
+	return e.Strategy + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *StrategyError) Unwrap() error {
+	return e.Err
+}
+
+// ErrInvalidFeedURL is matched by an *InvalidFeedError.
+var ErrInvalidFeedURL = errors.New("invalid feed URL")
+
+// An InvalidFeedError records a feed URL that was found on a
+// page but isn't safe to return, e.g. a relative URL or one
+// with a javascript: or data: scheme. Strategies that find an
+// invalid feed fail with an InvalidFeedError.
+type InvalidFeedError struct {
+	URL    string
+	Reason string
+}
+
+func (e *InvalidFeedError) Error() string {
+	return fmt.Sprintf("invalid feed URL %q: %s", e.URL, e.Reason)
+}
+
+// Is reports whether target is ErrInvalidFeedURL.
+func (e *InvalidFeedError) Is(target error) bool {
+	return target == ErrInvalidFeedURL
+}
+
+// ErrTruncated is reported by a *ReadError when a page or
+// plist ends before it should, e.g. because the connection
+// dropped.
+var ErrTruncated = errors.New("response ended early")
+
+// A ReadError is returned when a page or plist can't be read.
+// Err is the underlying error, or ErrTruncated in place of
+// io.EOF and io.ErrUnexpectedEOF.
+type ReadError struct {
+	Err error
+}
+
+func (e *ReadError) Error() string {
+	return "read error: " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *ReadError) Unwrap() error {
+	return e.Err
+}
+
+// readError wraps an error from reading a page or plist in a
+// *ReadError, so that low-level errors like io.EOF don't reach
+// callers as they are.
+func readError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = ErrTruncated
+	}
+	return &ReadError{Err: err}
+}
+
+// ErrITunesU and ErrAudiobook identify pages that have no
+// feed because they aren't podcasts. The ToRSS functions report
+// them with a *NoFeedError, which matches both ErrNoFeed and
+// the page's Kind via errors.Is.
+var (
+	ErrITunesU   = errors.New("iTunes U course")
+	ErrAudiobook = errors.New("audiobook")
+)
+
+// ErrInterstitial identifies Apple's "open in app" pages, which
+// redirect to an iTunes app and have no feed data. It is
+// matched by an *InterstitialError via errors.Is.
+var ErrInterstitial = errors.New("open in app page")
+
+// An InterstitialError is returned by ParseHTML for an "open
+// in app" page. URL is the page's continuation link, with any
+// itms: or itmss: scheme mapped to https. The ToRSS functions
+// follow the link automatically, like a Goto plist.
+type InterstitialError struct {
+	URL string
+}
+
+func (e *InterstitialError) Error() string {
+	return fmt.Sprintf("%s: continue at %q", ErrInterstitial, e.URL)
+}
+
+// Is reports whether target is ErrInterstitial.
+func (e *InterstitialError) Is(target error) bool {
+	return target == ErrInterstitial
+}
+
+// A NoFeedError is returned when every extraction strategy
+// fails. It lists the strategies attempted, in order, along
+// with the reasons they failed.
+type NoFeedError struct {
+	Errors []*StrategyError
+
+	// Kind, if set, is the kind of page that explains the
+	// missing feed, e.g. ErrITunesU.
+	Kind error
+
+	// Candidates are URLs on the page that look like feeds,
+	// most likely first. They are only set if the Options'
+	// HarvestFeeds is true.
+	Candidates []string
+}
+
+func (e *NoFeedError) Error() string {
+
+	msg := ErrNoFeed.Error()
+	if e.Kind != nil {
+		msg += ": " + e.Kind.Error()
+	}
+
+	if len(e.Errors) == 0 {
+		return msg
+	}
+
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+
+	return msg + " (" + strings.Join(msgs, "; ") + ")"
+}
+
+// Is reports whether target is ErrNoFeed or the Kind of page.
+func (e *NoFeedError) Is(target error) bool {
+	return target == ErrNoFeed || e.Kind != nil && target == e.Kind
+}
+
+// Unwrap returns the errors of the individual strategies.
+func (e *NoFeedError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
+}
+
 // A Client is responsible for executing HTTP requests. Its
 // interface is satisfied by http.Client. Provide your own
 // implementation to intercept requests and responses.
@@ -40,134 +210,1011 @@ func ToRSS(url string) (string, error) {
 // ToRSSClient returns the underlying RSS feed from an iTunes
 // URL using the provided Client.
 func ToRSSClient(url string, client Client) (string, error) {
+	return Options{}.ToRSSClient(url, client)
+}
+
+// ToRSSClient is like the package-level ToRSSClient but uses
+// the Options.
+func (o Options) ToRSSClient(url string, client Client) (string, error) {
+
+	feed, _, _, err := o.toRSS(url, client)
+	return feed, err
+}
+
+// toRSS is like ToRSSClient but also returns the canonical URL
+// of the iTunes page that the feed was found on, and the name
+// of the strategy that found it.
+func (o Options) toRSS(url string, client Client) (feed, page, strategy string, err error) {
 
 	if client == nil {
 		client = http.DefaultClient
 	}
 
-	feed, err := processURL(url, client, 0)
-	if err == io.EOF {
-		err = ErrNoFeed
+	u, err := podcastsURL(url)
+	if err != nil {
+		return "", "", "", &URLError{URL: url, Err: err}
 	}
 
-	return feed, err
+	feed, page, strategy, err = o.processURL(u, client, nil)
+	if err != nil || !isSelfReference(feed) {
+		return feed, page, strategy, err
+	}
+
+	// A show that points to a different show can be resolved
+	// once more. One that points to itself can't.
+	next, nerr := podcastsURL(feed)
+	if !o.FollowSelfReferences || nerr != nil || batchKey(feed) == batchKey(url) {
+		return "", "", "", &URLError{URL: url, Err: &SelfReferenceError{Feed: feed}}
+	}
+
+	feed, page, strategy, err = o.processURL(next, client, nil)
+	if err == nil && isSelfReference(feed) {
+		return "", "", "", &URLError{URL: next, Err: &SelfReferenceError{Feed: feed}}
+	}
+
+	return feed, page, strategy, err
+}
+
+// ErrSelfReference is matched by a *SelfReferenceError.
+var ErrSelfReference = errors.New("feed is an Apple Podcasts URL")
+
+// A SelfReferenceError is returned when the feed found for a
+// show is the URL of a show on Apple Podcasts, rather than an
+// RSS feed.
+type SelfReferenceError struct {
+	Feed string
+}
+
+func (e *SelfReferenceError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrSelfReference, e.Feed)
+}
+
+// Is reports whether target is ErrSelfReference.
+func (e *SelfReferenceError) Is(target error) bool {
+	return target == ErrSelfReference
+}
+
+// isSelfReference reports whether feed is the URL of a show on
+// Apple Podcasts.
+func isSelfReference(feed string) bool {
+	_, err := ParseURL(feed)
+	return err == nil
+}
+
+// A URLError records the URL at which an error occurred. Hop
+// is the number of Goto redirects followed to reach the URL,
+// so the URL passed to the ToRSS functions is hop 0. RequestID
+// is set for errors from a Batch to the RequestID of their
+// Result.
+type URLError struct {
+	URL       string
+	Hop       int
+	Err       error
+	RequestID string
+}
+
+func (e *URLError) Error() string {
+	if e.RequestID != "" {
+		return fmt.Sprintf("%q (hop %d, request %s): %s", e.URL, e.Hop, e.RequestID, e.Err)
+	}
+	return fmt.Sprintf("%q (hop %d): %s", e.URL, e.Hop, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *URLError) Unwrap() error {
+	return e.Err
+}
+
+// Timeout reports whether the request timed out. Along with
+// Temporary, it makes a *URLError a net.Error.
+func (e *URLError) Timeout() bool {
+	return isTimeout(e.Err)
+}
+
+// Temporary reports whether the request may succeed if it is
+// retried, e.g. after a timeout or a server error.
+func (e *URLError) Temporary() bool {
+	return isTemporary(e.Err)
 }
 
-func processURL(url string, client Client, redirects int) (string, error) {
+// ErrTooManyRedirects is matched by a *TooManyRedirectsError
+// via errors.Is.
+var ErrTooManyRedirects = errors.New("too many redirects")
+
+// A TooManyRedirectsError is returned when a chain of Goto
+// redirects is too long, e.g. because it loops. URLs lists the
+// URLs visited, in order, followed by the next URL, which
+// wasn't.
+type TooManyRedirectsError struct {
+	URLs []string
+}
+
+func (e *TooManyRedirectsError) Error() string {
+	return ErrTooManyRedirects.Error() + ": " + strings.Join(e.URLs, " -> ")
+}
+
+// Is reports whether target is ErrTooManyRedirects.
+func (e *TooManyRedirectsError) Is(target error) bool {
+	return target == ErrTooManyRedirects
+}
+
+// processURL resolves url, following Goto redirects. Visited
+// holds the URLs already visited on the way to url.
+func (o Options) processURL(url string, client Client, visited []string) (feed, page, strategy string, err error) {
+
+	hop := len(visited)
+	visited = append(visited, url)
+
+	feed, next, page, strategy, err := o.processPage(url, client)
+	if err != nil {
+		return "", "", "", &URLError{URL: url, Hop: hop, Err: err}
+	}
+
+	if next == "" {
+		return feed, page, strategy, nil
+	}
+
+	if hop+1 > maxRedirects {
+		urls := append(visited[:len(visited):len(visited)], next)
+		return "", "", "", &URLError{URL: url, Hop: hop, Err: &TooManyRedirectsError{URLs: urls}}
+	}
+
+	return o.processURL(next, client, visited)
+}
+
+// processPage fetches a single URL. It returns either the RSS
+// feed, the canonical URL of the page and the strategy that
+// found the feed or, if the URL points to a Goto plist, the
+// next URL to process.
+func (o Options) processPage(url string, client Client) (feed, next, page, strategy string, err error) {
 
 	resp, err := fetch(client, url)
 	if err != nil {
-		return "", fmt.Errorf("fetch error: %s", err)
+		return "", "", "", "", err
+	}
+	defer closeBody(resp.Body)
+
+	feed, next, strategy, err = o.toRSSReader(resp.Body, resp.Header.Get("Content-Type"))
+
+	if e, ok := err.(*UnexpectedContentTypeError); ok {
+		e.URL = url
+	}
+
+	// Follow an "open in app" page's continuation link like a
+	// Goto plist.
+	if e, ok := err.(*InterstitialError); ok {
+		return "", e.URL, "", "", nil
+	}
+
+	if err != nil || next != "" {
+		return "", next, "", "", err
+	}
+
+	// Use the URL of the last request, after any HTTP redirects.
+	page = url
+	if resp.Request != nil && resp.Request.URL != nil {
+		page = resp.Request.URL.String()
+	}
+
+	return feed, "", canonicalURL(page), strategy, nil
+}
+
+// canonicalURL returns a page URL without its fragment or any
+// query parameters apart from the language, l=. The others only
+// carry tracking and display options. Show URLs are returned in
+// the form of ShowURL.String, which keeps the show ID of
+// WebObjects URLs.
+// e.g. https://itunes.apple.com/us/podcast/serial/id917918570?mt=2&l=es
+// becomes https://itunes.apple.com/us/podcast/id917918570?l=es
+func canonicalURL(s string) string {
+
+	if show, err := ParseURL(s); err == nil {
+		return show.String()
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+
+	l := u.Query().Get("l")
+	u.RawQuery = ""
+	if l != "" {
+		u.RawQuery = "l=" + url.QueryEscape(l)
+	}
+	u.ForceQuery = false
+	u.Fragment = ""
+
+	return u.String()
+}
+
+// An UnexpectedContentTypeError is returned for responses
+// whose Content Type isn't HTML or XML. MediaType is the
+// Content Type without its parameters, e.g. "text/plain". URL
+// is set for responses fetched by the package.
+type UnexpectedContentTypeError struct {
+	ContentType string
+	MediaType   string
+	URL         string
+}
+
+func (e *UnexpectedContentTypeError) Error() string {
+	return fmt.Sprintf("unsupported Content Type %q", e.ContentType)
+}
+
+// A MalformedContentTypeError is returned for responses whose
+// Content Type can't be parsed. Err is the parse error.
+type MalformedContentTypeError struct {
+	ContentType string
+	Err         error
+}
+
+func (e *MalformedContentTypeError) Error() string {
+	return fmt.Sprintf("bad Content Type %q: %s", e.ContentType, e.Err)
+}
+
+// Unwrap returns the parse error.
+func (e *MalformedContentTypeError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	prefixXML     = []byte("<?xml")
+	prefixDoctype = []byte("<!doctype html")
+	prefixHTML    = []byte("<html")
+)
+
+// sniffPlainText returns a reader for the body in r and the
+// media type that its first bytes suggest, or "text/plain".
+func sniffPlainText(r io.Reader) (*bufio.Reader, string) {
+
+	br := bufio.NewReader(r)
+
+	// Peek returns an error if the body is shorter than 512
+	// bytes, which is fine.
+	head, _ := br.Peek(512)
+	head = bytes.TrimLeft(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf")), " \t\r\n")
+
+	hasPrefix := func(prefix []byte) bool {
+		return len(head) >= len(prefix) && bytes.EqualFold(head[:len(prefix)], prefix)
 	}
-	defer resp.Body.Close()
 
-	ctype := resp.Header.Get("Content-Type")
-	media, _, err := mime.ParseMediaType(ctype)
+	switch {
+	case hasPrefix(prefixXML):
+		return br, "text/xml"
+	case hasPrefix(prefixDoctype), hasPrefix(prefixHTML):
+		return br, "text/html"
+	default:
+		return br, "text/plain"
+	}
+}
+
+// ToRSSReader returns the underlying RSS feed from the body
+// of an iTunes response with the given Content Type. If the
+// body is an iTunes plist, ToRSSReader returns the next URL
+// to fetch instead of a feed. Use it to make the HTTP requests
+// yourself.
+func ToRSSReader(r io.Reader, contentType string) (feed, next string, err error) {
+	return Options{}.ToRSSReader(r, contentType)
+}
+
+// ToRSSReader is like the package-level ToRSSReader but uses
+// the Options.
+func (o Options) ToRSSReader(r io.Reader, contentType string) (feed, next string, err error) {
+
+	feed, next, _, err = o.toRSSReader(r, contentType)
+	return feed, next, err
+}
+
+// toRSSReader is like ToRSSReader but also returns the name of
+// the strategy that found the feed.
+func (o Options) toRSSReader(r io.Reader, contentType string) (feed, next, strategy string, err error) {
+
+	media, params, err := mime.ParseMediaType(contentType)
 	if err != nil {
-		return "", fmt.Errorf("bad Content Type %q: %s", ctype, err)
+		return "", "", "", &MalformedContentTypeError{ContentType: contentType, Err: err}
+	}
+
+	// Extractors see the sniffed Content Type, if any.
+	sniffed := contentType
+	if media == "text/plain" && o.SniffPlainText {
+		var br *bufio.Reader
+		br, media = sniffPlainText(r)
+		r = br
+		if media != "text/plain" {
+			sniffed = mime.FormatMediaType(media, params)
+		}
+	}
+
+	var x *namedExtractor
+	if x, r = findExtractor(r, sniffed); x != nil {
+		feed, next, err = x.extract(r)
+		return feed, next, x.name, err
 	}
 
 	switch media {
-	case "text/html":
-		return processHTML(resp.Body)
+	case "text/html", "application/xhtml+xml":
+		feed, strategy, err = o.parseHTML(r)
+		return feed, "", strategy, err
 
 	case "text/xml", "application/xml":
-		next, err := processXML(resp.Body)
+		target, err := o.ParsePlist(r)
+		return "", target.URL, "", err
+
+	default:
+		return "", "", "", &UnexpectedContentTypeError{ContentType: contentType, MediaType: media}
+	}
+}
+
+// ToRSSFile is like ToRSSReader but reads from a saved iTunes
+// page or plist on disk. The Content Type is determined from
+// the contents of the file.
+func ToRSSFile(filename string) (feed, next string, err error) {
+	return Options{}.ToRSSFile(filename)
+}
+
+// ToRSSFile is like the package-level ToRSSFile but uses the
+// Options.
+func (o Options) ToRSSFile(filename string) (feed, next string, err error) {
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+
+	r := bufio.NewReaderSize(f, o.readBufferSize())
+
+	// DetectContentType considers at most 512 bytes. Peek
+	// returns an error if the file is shorter than that,
+	// which is fine.
+	head, _ := r.Peek(512)
+
+	return o.ToRSSReader(r, http.DetectContentType(head))
+}
+
+// A strategy is a named technique for finding the RSS feed
+// in the body of an iTunes page.
+type strategy struct {
+	name    string
+	extract func(body []byte) (string, error)
+}
+
+// htmlStrategies are attempted in order until one of them
+// finds a feed. Desktop pages come first, then the mobile and
+// AMP variants, then the JSON of current and archived web app
+// pages.
+var htmlStrategies = []strategy{
+	{"feed-url attribute", extractFeedURLAttr},
+	{"feed attribute", extractFeedAttr},
+	{"RSS link", extractFeedLink},
+	{"serialized server data", extractServerData},
+	{"shoebox JSON", extractShoebox},
+}
+
+// ParseHTML returns the RSS feed from the HTML of an iTunes
+// page. Use it to process pages that have already been
+// downloaded. If no feed is found, ParseHTML returns a
+// *NoFeedError, an error matching ErrBlocked if the page is a
+// CAPTCHA, or an *InterstitialError if it is an "open in app"
+// page. Pages larger than Options.MaxPageSize fail with
+// ErrTooLarge, and pages that can't be read fail with a
+// *ReadError.
+func ParseHTML(r io.Reader) (string, error) {
+	return Options{}.ParseHTML(r)
+}
+
+// ParseHTML is like the package-level ParseHTML but uses the
+// Options.
+func (o Options) ParseHTML(r io.Reader) (string, error) {
+
+	feed, _, err := o.parseHTML(r)
+	return feed, err
+}
+
+// parseHTML is like ParseHTML but also returns the name of the
+// strategy that found the feed.
+func (o Options) parseHTML(r io.Reader) (feed, strategy string, err error) {
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	body, err := o.readPage(r, buf)
+	if err != nil {
+		return "", "", err
+	}
+
+	feed, strategy, err = runStrategies(htmlStrategies, body)
+	if err != nil {
+		return "", "", o.pageError(body, err)
+	}
+
+	return feed, strategy, nil
+}
+
+// readPage reads an HTML page into buf and returns its bytes.
+func (o Options) readPage(r io.Reader, buf *bytes.Buffer) ([]byte, error) {
+
+	max := o.maxPageSize()
+
+	if _, err := buf.ReadFrom(io.LimitReader(r, max+1)); err != nil {
+		return nil, readError(err)
+	}
+
+	if int64(buf.Len()) > max {
+		return nil, ErrTooLarge
+	}
+
+	return buf.Bytes(), nil
+}
+
+// pageError returns the error for a page where the strategies
+// failed with err.
+func (o Options) pageError(body []byte, err error) error {
+
+	if e, ok := err.(*NoFeedError); ok {
+		if next := interstitialURL(body); next != "" {
+			return &InterstitialError{URL: next}
+		}
+		e.Kind = pageKind(body)
+		if e.Kind == nil && isCaptcha(body) {
+			return errCaptcha
+		}
+		if o.HarvestFeeds {
+			e.Candidates = harvestFeeds(body)
+		}
+	}
+
+	return err
+}
+
+// A FeedMatch is a feed found on a page by ParseHTMLFeeds.
+type FeedMatch struct {
+	URL string
+
+	// Strategies names the strategies that found the feed, in
+	// the order they were attempted.
+	Strategies []string
+
+	// Confidence is the fraction of the strategies that found
+	// a feed that found this one, from 0 to 1.
+	Confidence float64
+}
+
+// ParseHTMLFeeds is like ParseHTML but attempts every strategy
+// and returns each feed found, most likely first. A page
+// usually has one feed, but old or edited pages can disagree
+// with themselves. Matches with the same Confidence are in the
+// order of the first strategy that found them, so the first
+// match is only ParseHTML's feed if no other feed is found by
+// more strategies.
+func ParseHTMLFeeds(r io.Reader) ([]FeedMatch, error) {
+	return Options{}.ParseHTMLFeeds(r)
+}
+
+// ParseHTMLFeeds is like the package-level ParseHTMLFeeds but
+// uses the Options.
+func (o Options) ParseHTMLFeeds(r io.Reader) ([]FeedMatch, error) {
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	body, err := o.readPage(r, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []FeedMatch
+	index := map[string]int{}
+	found := 0
+	e := &NoFeedError{}
+
+	for _, s := range htmlStrategies {
+
+		feed, err := s.extract(body)
+		if err == nil {
+			feed, err = checkFeedURL(normalizeURL(feed))
+		}
 		if err != nil {
-			return "", err
+			e.Errors = append(e.Errors, &StrategyError{
+				Strategy: s.name,
+				Err:      err,
+			})
+			continue
 		}
-		redirects++
-		if redirects > maxRedirects {
-			return "", errors.New("too many redirects")
+
+		found++
+		i, ok := index[feed]
+		if !ok {
+			i = len(matches)
+			index[feed] = i
+			matches = append(matches, FeedMatch{URL: feed})
 		}
+		matches[i].Strategies = append(matches[i].Strategies, s.name)
+	}
 
-		return processURL(next, client, redirects)
+	if found == 0 {
+		return nil, o.pageError(body, e)
+	}
 
-	default:
-		return "", fmt.Errorf("unsupported Content Type %q", ctype)
+	for i := range matches {
+		matches[i].Confidence = float64(len(matches[i].Strategies)) / float64(found)
 	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Confidence > matches[j].Confidence
+	})
+
+	return matches, nil
 }
 
-func processHTML(r io.Reader) (string, error) {
+var (
+	tagMeta         = []byte("meta")
+	attrHTTPEquiv   = []byte("http-equiv")
+	attrContent     = []byte("content")
+	reRefreshTarget = regexp.MustCompile(`(?i)^\s*\d+\s*;\s*url\s*=\s*['"]?([^'"\s]+)`)
+)
 
-	var attr, val []byte
+// interstitialURL returns the continuation link of an "open in
+// app" page, or "" if body isn't one. These pages send the
+// browser on to an iTunes app with a meta refresh.
+// e.g. <meta http-equiv="refresh" content="0; url=itmss://itunes.apple.com/us/podcast/id917918570">
+func interstitialURL(body []byte) string {
 
-	tagButton := []byte("button")
-	attrFeed := []byte("feed-url")
+	body = preFilter(body, attrHTTPEquiv)
+	if body == nil {
+		return ""
+	}
 
-	z := html.NewTokenizer(r)
+	z := html.NewTokenizer(bytes.NewReader(body))
 
 	for {
 		tt := z.Next()
 
 		if tt == html.ErrorToken {
-			break
+			return ""
 		}
 
-		if tt != html.StartTagToken {
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
 			continue
 		}
 
 		tag, hasAttrs := z.TagName()
-		if !bytes.Equal(tag, tagButton) {
+		if !bytes.Equal(tag, tagMeta) {
 			continue
 		}
 
+		var refresh bool
+		var content string
 		for hasAttrs {
+			var attr, val []byte
 			attr, val, hasAttrs = z.TagAttr()
-			if bytes.Equal(attr, attrFeed) && len(val) > 0 {
-				return string(val), nil
+			switch {
+			case bytes.Equal(attr, attrHTTPEquiv):
+				refresh = strings.EqualFold(string(val), "refresh")
+			case bytes.Equal(attr, attrContent):
+				content = string(val)
 			}
 		}
+
+		if !refresh {
+			continue
+		}
+
+		m := reRefreshTarget.FindStringSubmatch(content)
+		if m == nil {
+			return ""
+		}
+
+		u, err := url.Parse(m[1])
+		if err != nil || !isAppleHost(u.Hostname()) {
+			return ""
+		}
+
+		switch strings.ToLower(u.Scheme) {
+		case "itms", "itmss", "http", "https":
+			u.Scheme = "https"
+			return u.String()
+		}
+
+		return ""
+	}
+}
+
+var attrContentURL = []byte(`twitter-content-url="`)
+
+// pageKind returns ErrITunesU or ErrAudiobook if the page is
+// for an iTunes U course or an audiobook, or nil otherwise.
+// The first share link on a page is for the page's item.
+// e.g. twitter-content-url="https://itunes.apple.com/us/itunes-u/..."
+func pageKind(body []byte) error {
+
+	i := bytes.Index(body, attrContentURL)
+	if i < 0 {
+		return nil
 	}
 
-	return "", z.Err()
+	rest := body[i+len(attrContentURL):]
+	if j := bytes.IndexByte(rest, '"'); j >= 0 {
+		rest = rest[:j]
+	}
+
+	u, err := url.Parse(string(rest))
+	if err != nil {
+		return nil
+	}
+
+	for _, seg := range strings.Split(u.Path, "/") {
+		switch seg {
+		case "itunes-u":
+			return ErrITunesU
+		case "audiobook":
+			return ErrAudiobook
+		}
+	}
+
+	return nil
 }
 
-var (
-	// This line comes directly before the URL line in a Goto file.
-	prevLine = []byte("<key>kind</key><string>Goto</string>")
+// maxPooledBuffer is the capacity above which buffers aren't
+// returned to the pool, so that one huge page doesn't pin its
+// memory.
+const maxPooledBuffer = 8 << 20
 
-	// This regex extracts the URL from a Goto file.
-	// Matches: <key>url</key><string>path/to/itunes-page</string>
-	reGoto = regexp.MustCompile(`^<key>url</key><string>(\S+)</string>$`)
-)
+// bufferPool holds the buffers that pages are read into.
+// Strategies only return copies of a page's bytes, so its
+// buffer can be reused once it has been parsed.
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
 
-func processXML(r io.Reader) (string, error) {
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
 
-	scanner := bufio.NewScanner(r)
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBuffer {
+		bufferPool.Put(buf)
+	}
+}
 
-	for scanner.Scan() {
+var markerCaptcha = []byte("captcha")
+
+// captchaTags are the elements that make up a CAPTCHA
+// challenge: the widget's container, script and iframe, and
+// the form that submits the answer.
+var captchaTags = map[string]bool{
+	"div":    true,
+	"form":   true,
+	"iframe": true,
+	"input":  true,
+	"script": true,
+}
+
+// isCaptcha reports whether an HTML page has a CAPTCHA
+// challenge: one of captchaTags with an attribute that names a
+// CAPTCHA, in any case.
+// e.g. <div class="g-recaptcha"> or <script src="https://www.google.com/recaptcha/api.js">
+// Pages that only mention CAPTCHAs in their text don't count.
+func isCaptcha(body []byte) bool {
+
+	i := indexFold(body, markerCaptcha)
+	if i < 0 {
+		return false
+	}
+
+	if j := bytes.LastIndexByte(body[:i], '<'); j >= 0 {
+		body = body[j:]
+	}
 
-		if !bytes.Equal(scanner.Bytes(), prevLine) {
+	z := html.NewTokenizer(bytes.NewReader(body))
+
+	for {
+		tt := z.Next()
+
+		if tt == html.ErrorToken {
+			return false
+		}
+
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
 			continue
 		}
 
-		if !scanner.Scan() {
+		tag, hasAttrs := z.TagName()
+		if !captchaTags[string(tag)] {
+			continue
+		}
+
+		for hasAttrs {
+			var val []byte
+			_, val, hasAttrs = z.TagAttr()
+			if indexFold(val, markerCaptcha) >= 0 {
+				return true
+			}
+		}
+	}
+}
+
+// indexFold returns the index of the first occurrence of the
+// lowercase ASCII marker in b, in any case, or -1.
+func indexFold(b, marker []byte) int {
+
+	n := len(marker)
+	for i := 0; i+n <= len(b); i++ {
+		if c := b[i] | 0x20; c == marker[0] && bytes.EqualFold(b[i:i+n], marker) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// runStrategies returns the feed found by the first strategy
+// that succeeds, and its name.
+func runStrategies(strategies []strategy, body []byte) (feed, name string, err error) {
+
+	e := &NoFeedError{}
+
+	for _, s := range strategies {
+		feed, err := s.extract(body)
+		if err == nil {
+			feed, err = checkFeedURL(normalizeURL(feed))
+		}
+		if err == nil {
+			return feed, s.name, nil
+		}
+		e.Errors = append(e.Errors, &StrategyError{
+			Strategy: s.name,
+			Err:      err,
+		})
+	}
+
+	return "", "", e
+}
+
+var errNoFeedURLAttr = errors.New("no button with a feed-url attribute")
+
+var (
+	tagButton   = []byte("button")
+	attrFeedURL = []byte("feed-url")
+)
+
+// preFilter returns the part of body that starts at the tag
+// containing the first occurrence of marker, or nil if body
+// doesn't contain marker. iTunes pages are large, so skipping
+// the markup before the feed saves a lot of tokenizing.
+func preFilter(body, marker []byte) []byte {
+
+	i := bytes.Index(body, marker)
+	if i < 0 {
+		return nil
+	}
+
+	if j := bytes.LastIndexByte(body[:i], '<'); j >= 0 {
+		i = j
+	}
+
+	return body[i:]
+}
+
+func extractFeedURLAttr(body []byte) (string, error) {
+
+	var attr, val []byte
+
+	body = preFilter(body, attrFeedURL)
+	if body == nil {
+		return "", errNoFeedURLAttr
+	}
+
+	z := html.NewTokenizer(bytes.NewReader(body))
+
+	for {
+		tt := z.Next()
+
+		if tt == html.ErrorToken {
 			break
 		}
 
-		matches := reGoto.FindSubmatch(scanner.Bytes())
-		if len(matches) != 2 {
+		if tt != html.StartTagToken {
+			continue
+		}
+
+		tag, hasAttrs := z.TagName()
+		if !bytes.Equal(tag, tagButton) {
 			continue
 		}
 
-		// Unescape URL.
-		// e.g. https://itunes.apple.com/WebObjects/DZR.woa/wa/viewPodcast?urlDesc=&amp;id=1234567890
-		// becomes https://itunes.apple.com/WebObjects/DZR.woa/wa/viewPodcast?urlDesc=&id=1234567890
-		return html.UnescapeString(string(matches[1])), nil
+		for hasAttrs {
+			attr, val, hasAttrs = z.TagAttr()
+			if bytes.Equal(attr, attrFeedURL) && len(val) > 0 {
+				return string(val), nil
+			}
+		}
+	}
+
+	if err := z.Err(); err != io.EOF {
+		return "", err
 	}
 
-	err := scanner.Err()
+	return "", errNoFeedURLAttr
+}
+
+// gotoStrategy names the search for a Goto action in a plist.
+const gotoStrategy = "Goto plist"
+
+var errNoGoto = errors.New("no Goto action with a URL")
+
+// A GotoTarget is the destination of a Goto action in an
+// iTunes plist.
+type GotoTarget struct {
+	// URL is the unescaped URL of the next page to process.
+	URL string
+}
+
+// ParsePlist returns the destination of the Goto action in an
+// iTunes plist. Use it to process plists that have already
+// been downloaded. If the plist has no Goto action, ParsePlist
+// returns a *NoFeedError. Plists that can't be read fail with
+// a *ReadError.
+func ParsePlist(r io.Reader) (GotoTarget, error) {
+	return Options{}.ParsePlist(r)
+}
+
+// ParsePlist is like the package-level ParsePlist but uses the
+// Options.
+func (o Options) ParsePlist(r io.Reader) (GotoTarget, error) {
+
+	scanner := bufio.NewScanner(r)
+
+	max := o.maxPlistLine()
+	size := defaultReadBufferSize
+	if size > max {
+		size = max
+	}
+	scanner.Buffer(make([]byte, 0, size), max)
+
+	url, err := findGoto(&lineReader{scanner: scanner})
 	if err == nil {
-		// If Scan() returns false but Err() is nil,
-		// we've reached the end of the input.
-		err = io.EOF
+		return GotoTarget{URL: url}, nil
+	}
+
+	if _, ok := err.(*xml.SyntaxError); !ok && err != errNoGoto && err != bufio.ErrTooLong {
+		return GotoTarget{}, readError(err)
+	}
+
+	return GotoTarget{}, &NoFeedError{
+		Errors: []*StrategyError{
+			{Strategy: gotoStrategy, Err: err},
+		},
+	}
+}
+
+// A plistDict holds the keys of a plist dict that identify a
+// Goto action.
+type plistDict struct {
+	key  string // the last key read
+	kind string
+	url  string
+}
+
+func (d *plistDict) isGoto() bool {
+	return strings.EqualFold(d.kind, "Goto") && d.url != ""
+}
+
+// findGoto returns the URL of the first Goto action in a plist,
+// i.e. a dict with a kind of "Goto" and a url, in any order.
+// Keys and values are matched ignoring case and surrounding
+// whitespace. The XML is unescaped, so a URL like
+// https://itunes.apple.com/WebObjects/DZR.woa/wa/viewPodcast?urlDesc=&amp;id=1234567890
+// becomes https://itunes.apple.com/WebObjects/DZR.woa/wa/viewPodcast?urlDesc=&id=1234567890
+func findGoto(r io.Reader) (string, error) {
+
+	d := xml.NewDecoder(r)
+	d.Strict = false
+	d.Entity = xml.HTMLEntity
+
+	var dicts []*plistDict
+
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			// Accept a Goto action from a truncated plist
+			// if it is complete.
+			if n := len(dicts); n > 0 && dicts[n-1].isGoto() {
+				return dicts[n-1].url, nil
+			}
+			if err == io.EOF {
+				err = errNoGoto
+			}
+			return "", err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+
+			if t.Name.Local == "dict" {
+				dicts = append(dicts, &plistDict{})
+				continue
+			}
+
+			if len(dicts) == 0 {
+				continue
+			}
+			dict := dicts[len(dicts)-1]
+
+			switch t.Name.Local {
+			case "key":
+				var key string
+				if err := d.DecodeElement(&key, &t); err != nil {
+					return "", err
+				}
+				dict.key = strings.ToLower(strings.TrimSpace(key))
+
+			case "string":
+				var val string
+				if err := d.DecodeElement(&val, &t); err != nil {
+					return "", err
+				}
+				switch dict.key {
+				case "kind":
+					dict.kind = strings.TrimSpace(val)
+				case "url":
+					dict.url = strings.TrimSpace(val)
+				}
+				dict.key = ""
+
+			default:
+				dict.key = ""
+			}
+
+		case xml.EndElement:
+
+			if t.Name.Local != "dict" || len(dicts) == 0 {
+				continue
+			}
+
+			dict := dicts[len(dicts)-1]
+			if dict.isGoto() {
+				return dict.url, nil
+			}
+			dicts = dicts[:len(dicts)-1]
+		}
 	}
+}
 
-	return "", err
+// A lineReader reads lines from a bufio.Scanner, so that the
+// scanner's limit on line length applies.
+type lineReader struct {
+	scanner *bufio.Scanner
+	buf     []byte
 }
 
+func (r *lineReader) Read(p []byte) (int, error) {
+
+	if len(r.buf) == 0 {
+		if !r.scanner.Scan() {
+			if err := r.scanner.Err(); err != nil {
+				return 0, err
+			}
+			return 0, io.EOF
+		}
+		r.buf = append(append(r.buf[:0], r.scanner.Bytes()...), '\n')
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+
+	return n, nil
+}
+
+// acceptTypes are the media types that the package can use,
+// covering pages, plists, API results and artwork.
+const acceptTypes = "text/html, application/xhtml+xml, application/xml;q=0.9, application/json;q=0.9, */*;q=0.8"
+
 func newRequest(u string) (*http.Request, error) {
 
 	req, err := http.NewRequest("GET", u, nil)
@@ -181,24 +1228,205 @@ func newRequest(u string) (*http.Request, error) {
 	// Make requests look like they come from iTunes.
 	req.Header.Set("User-Agent", iTunesUA)
 
+	// Every request asks for the same types, so that caches
+	// that Vary on Accept can reuse their responses.
+	req.Header.Set("Accept", acceptTypes)
+
+	if sf := storefrontHeader(req.URL); sf != "" {
+		req.Header.Set("X-Apple-Store-Front", sf)
+	}
+
 	return req, nil
 }
 
+// A RedirectResult is returned when a request gets an HTTP
+// redirect response. The package doesn't follow redirects
+// itself, and a Client like http.Client normally follows them
+// before the package sees them. To vet every outbound request,
+// use a Client that doesn't follow redirects, e.g. an
+// http.Client whose CheckRedirect returns
+// http.ErrUseLastResponse. The caller can then resolve
+// Location itself.
+type RedirectResult struct {
+	URL        string // the URL that was redirected
+	Location   string // the absolute URL of the redirect
+	StatusCode int
+}
+
+func (r *RedirectResult) Error() string {
+	return fmt.Sprintf("redirected (%d) to %q", r.StatusCode, r.Location)
+}
+
+func isRedirect(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// A BadURLError is returned for URLs that can't be parsed,
+// before any request is made. Err is the parse error.
+type BadURLError struct {
+	URL string
+	Err error
+}
+
+func (e *BadURLError) Error() string {
+	return "bad URL: " + e.Err.Error()
+}
+
+// Unwrap returns the parse error.
+func (e *BadURLError) Unwrap() error {
+	return e.Err
+}
+
+// A fetchError is an error fetching a URL. Temporary errors,
+// such as network failures and server errors, may succeed if
+// the request is retried.
+type fetchError struct {
+	err       error
+	temporary bool
+}
+
+func (e *fetchError) Error() string {
+	return "fetch error: " + e.err.Error()
+}
+
+func (e *fetchError) Unwrap() error {
+	return e.err
+}
+
+// Is reports whether target is context.DeadlineExceeded and the
+// fetch timed out. Older versions of net/http don't wrap
+// context.DeadlineExceeded in their client timeouts.
+func (e *fetchError) Is(target error) bool {
+	return target == context.DeadlineExceeded && isTimeout(e.err)
+}
+
+// headCheck sends a HEAD request for url and returns an error
+// if the response shows that the URL can't lead to a feed:
+// its Content Type isn't HTML or XML, or it is larger than
+// the MaxPageSize of the Options. Failed HEAD requests aren't
+// conclusive, so they return nil.
+func (o Options) headCheck(client Client, url string) error {
+
+	req, err := newRequest(url)
+	if err != nil {
+		return nil
+	}
+	req.Method = "HEAD"
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil
+	}
+	closeBody(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+
+	if ctype := resp.Header.Get("Content-Type"); ctype != "" {
+		media, _, err := mime.ParseMediaType(ctype)
+		if err == nil && media != "text/html" && media != "application/xhtml+xml" && media != "text/xml" && media != "application/xml" {
+			return &UnexpectedContentTypeError{ContentType: ctype, MediaType: media, URL: url}
+		}
+	}
+
+	if resp.ContentLength > o.maxPageSize() {
+		return ErrTooLarge
+	}
+
+	return nil
+}
+
+// maxDrain is the most that closeBody reads from a response
+// body before closing it.
+const maxDrain = 64 << 10
+
+// closeBody reads any remainder of a response body, up to
+// maxDrain bytes, and closes it. A body that is read to the
+// end lets the client reuse the connection for the next
+// request, e.g. the next hop in a Goto chain.
+func closeBody(body io.ReadCloser) {
+	io.CopyN(ioutil.Discard, body, maxDrain)
+	body.Close()
+}
+
+// A statusError is an unsuccessful HTTP response.
+type statusError struct {
+	status string
+	code   int
+}
+
+func (e *statusError) Error() string {
+	return e.status
+}
+
+// Is reports whether target is ErrBlocked and the response
+// was a 403 Forbidden.
+func (e *statusError) Is(target error) bool {
+	return target == ErrBlocked && e.code == http.StatusForbidden
+}
+
+// StatusCode returns the HTTP status code of the unsuccessful
+// response that caused err, or 0 if err wasn't caused by one.
+func StatusCode(err error) int {
+	var e *statusError
+	if errors.As(err, &e) {
+		return e.code
+	}
+	return 0
+}
+
+// isTemporary reports whether err is a temporary fetch error,
+// a failure to read a response body, or a timeout.
+func isTemporary(err error) bool {
+	var e *fetchError
+	var re *ReadError
+	return errors.As(err, &e) && e.temporary || errors.As(err, &re) || isTimeout(err)
+}
+
+// isTimeout reports whether err is a timeout or a deadline
+// being exceeded.
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout() || errors.Is(err, context.DeadlineExceeded)
+}
+
 func fetch(client Client, url string) (*http.Response, error) {
 
 	req, err := newRequest(url)
 	if err != nil {
-		return nil, fmt.Errorf("bad URL: %s", err)
+		return nil, &BadURLError{URL: url, Err: err}
 	}
 
+	// A canceled request fails the same way however often it's
+	// retried.
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, &fetchError{err: err, temporary: !errors.Is(err, context.Canceled)}
+	}
+
+	if isRedirect(resp.StatusCode) {
+		if loc, err := resp.Location(); err == nil {
+			closeBody(resp.Body)
+			return nil, &RedirectResult{
+				URL:        url,
+				Location:   loc.String(),
+				StatusCode: resp.StatusCode,
+			}
+		}
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		resp.Body.Close()
-		return nil, errors.New(resp.Status)
+		closeBody(resp.Body)
+		return nil, &fetchError{
+			err:       &statusError{status: resp.Status, code: resp.StatusCode},
+			temporary: resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests,
+		}
 	}
 
 	return resp, nil
@@ -1,16 +1,26 @@
 package itunes_test
 
+//go:generate go run ./cmd/fixtures
+
 import (
+	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"mime"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/deepilla/itunes"
+	"github.com/deepilla/itunes/itunestest"
 )
 
 func TestToRSS(t *testing.T) {
@@ -110,7 +120,7 @@ func TestToRSS(t *testing.T) {
 				"errors/too-many-redirects/plist-4",
 				"errors/too-many-redirects/plist-recursive",
 			},
-			Err: errors.New("too many redirects"),
+			Err: itunes.ErrTooManyRedirects,
 		},
 	}
 
@@ -124,6 +134,12 @@ func TestToRSS(t *testing.T) {
 
 			feed, err := itunes.ToRSSClient(url, client)
 
+			// Location details are covered by TestURLError.
+			var e *itunes.URLError
+			if errors.As(err, &e) {
+				err = e.Err
+			}
+
 			if !equalErrors(err, test.Err) {
 				t.Errorf("%s [%d/%d]: expected error %s, got %s", name, i+1, len(test.Paths), formatError(test.Err), formatError(err))
 			}
@@ -135,6 +151,614 @@ func TestToRSS(t *testing.T) {
 	}
 }
 
+func TestNoFeedError(t *testing.T) {
+
+	data := map[string][]string{
+		"errors/no-feed/itunes-no-episodes": {
+			"feed-url attribute",
+			"feed attribute",
+			"RSS link",
+			"serialized server data",
+			"shoebox JSON",
+		},
+		"errors/no-feed/plist-blank-url": {
+			"Goto plist",
+		},
+	}
+
+	ts := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer ts.Close()
+
+	client := redirectRequests(ts, http.DefaultClient)
+
+	for path, strategies := range data {
+
+		_, err := itunes.ToRSSClient(path, client)
+
+		var e *itunes.NoFeedError
+		if !errors.As(err, &e) {
+			t.Errorf("%s: expected a NoFeedError, got %s", path, formatError(err))
+			continue
+		}
+
+		if !errors.Is(err, itunes.ErrNoFeed) {
+			t.Errorf("%s: expected error to match ErrNoFeed", path)
+		}
+
+		if len(e.Errors) != len(strategies) {
+			t.Errorf("%s: expected %d strategy errors, got %d", path, len(strategies), len(e.Errors))
+			continue
+		}
+
+		for i, name := range strategies {
+			if got := e.Errors[i].Strategy; got != name {
+				t.Errorf("%s: expected strategy %q, got %q", path, name, got)
+			}
+			if e.Errors[i].Err == nil {
+				t.Errorf("%s: expected a reason for strategy %q", path, name)
+			}
+		}
+	}
+}
+
+func TestURLError(t *testing.T) {
+
+	data := map[string]struct {
+		URL string
+		Hop int
+	}{
+		"errors/no-feed/itunes-no-episodes": {
+			URL: "errors/no-feed/itunes-no-episodes",
+			Hop: 0,
+		},
+		"podcasts/s-town/plist-3": {
+			URL: "podcasts/s-town/itunes-page?cc=mx&l=en&urlDesc=%2Fs-town&mt=2&id=1212558767",
+			Hop: 3,
+		},
+		"errors/too-many-redirects/plist-4": {
+			URL: "podcasts/s-town/plist-1",
+			Hop: 3,
+		},
+	}
+
+	ts := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer ts.Close()
+
+	files := redirectRequests(ts, http.DefaultClient)
+
+	// Serve the final S-Town page as a missing page so that
+	// the chain starting at plist-3 fails on its last hop.
+	client := clientFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path == "podcasts/s-town/itunes-page" {
+			req.URL.Path = "missing"
+		}
+		return files.Do(req)
+	})
+
+	for path, exp := range data {
+
+		_, err := itunes.ToRSSClient(path, client)
+
+		var e *itunes.URLError
+		if !errors.As(err, &e) {
+			t.Errorf("%s: expected a URLError, got %s", path, formatError(err))
+			continue
+		}
+
+		if e.URL != exp.URL || e.Hop != exp.Hop {
+			t.Errorf("%s: expected error at %q (hop %d), got %q (hop %d)", path, exp.URL, exp.Hop, e.URL, e.Hop)
+		}
+	}
+}
+
+func TestTooManyRedirects(t *testing.T) {
+
+	data := map[string][]string{
+		"errors/too-many-redirects/plist-4": {
+			"errors/too-many-redirects/plist-4",
+			"podcasts/s-town/plist-3",
+			"podcasts/s-town/plist-2",
+			"podcasts/s-town/plist-1",
+			"podcasts/s-town/itunes-page?cc=mx&l=en&urlDesc=%2Fs-town&mt=2&id=1212558767",
+		},
+		"errors/too-many-redirects/plist-recursive": {
+			"errors/too-many-redirects/plist-recursive",
+			"errors/too-many-redirects/plist-recursive",
+			"errors/too-many-redirects/plist-recursive",
+			"errors/too-many-redirects/plist-recursive",
+			"errors/too-many-redirects/plist-recursive",
+		},
+	}
+
+	ts := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer ts.Close()
+
+	client := redirectRequests(ts, http.DefaultClient)
+
+	for path, exp := range data {
+
+		_, err := itunes.ToRSSClient(path, client)
+
+		var e *itunes.TooManyRedirectsError
+		if !errors.As(err, &e) {
+			t.Errorf("%s: expected a TooManyRedirectsError, got %s", path, formatError(err))
+			continue
+		}
+
+		if !errors.Is(err, itunes.ErrTooManyRedirects) {
+			t.Errorf("%s: expected error to match ErrTooManyRedirects", path)
+		}
+
+		if got, want := strings.Join(e.URLs, "\n"), strings.Join(exp, "\n"); got != want {
+			t.Errorf("%s: expected URLs\n%s\ngot\n%s", path, want, got)
+		}
+
+		if chain := strings.Join(exp, " -> "); !strings.Contains(err.Error(), chain) {
+			t.Errorf("%s: expected error message to contain %q, got %q", path, chain, err.Error())
+		}
+	}
+}
+
+func TestSelfReference(t *testing.T) {
+
+	const feed = "http://feeds.serialpodcast.org/serialpodcast"
+
+	s := itunestest.NewServer(
+		itunestest.Show{ID: 1, Feed: "https://podcasts.apple.com/us/podcast/serial/id917918570"},
+		itunestest.Show{ID: 2, Feed: "https://itunes.apple.com/us/podcast/id2"},
+		itunestest.Show{ID: 3, Feed: "https://podcasts.apple.com/us/podcast/id2"},
+		itunestest.Show{ID: 4, Feed: "https://music.apple.com/us/album/foo/id123"},
+		itunestest.Show{ID: 917918570, Feed: feed},
+	)
+	defer s.Close()
+
+	data := []struct {
+		ID     int
+		Follow bool
+		Feed   string
+	}{
+		{ID: 1, Follow: false},
+		{ID: 1, Follow: true, Feed: feed},
+		// Shows that point to themselves can't be followed.
+		{ID: 2, Follow: true},
+		// Nor can chains of shows.
+		{ID: 3, Follow: true},
+		// Other Apple URLs aren't shows.
+		{ID: 4, Feed: "https://music.apple.com/us/album/foo/id123"},
+	}
+
+	for _, test := range data {
+
+		opts := itunes.Options{FollowSelfReferences: test.Follow}
+
+		u := fmt.Sprintf("https://itunes.apple.com/us/podcast/id%d", test.ID)
+		got, err := opts.ToRSSClient(u, s.Client())
+
+		if got != test.Feed {
+			t.Errorf("%s (follow %t): expected feed %q, got %q", u, test.Follow, test.Feed, got)
+		}
+
+		var e *itunes.SelfReferenceError
+		if test.Feed == "" && (!errors.As(err, &e) || !errors.Is(err, itunes.ErrSelfReference)) {
+			t.Errorf("%s (follow %t): expected a SelfReferenceError, got %s", u, test.Follow, formatError(err))
+		}
+	}
+}
+
+func TestParseHTML(t *testing.T) {
+
+	data := map[string]struct {
+		Feed string
+		Err  error
+	}{
+		"podcasts/go-time/itunes-page": {
+			Feed: "https://changelog.com/gotime/feed",
+		},
+		"podcasts/s-town/itunes-page": {
+			Feed: "http://feeds.stownpodcast.org/stownpodcast",
+		},
+		"errors/no-feed/itunes-itunesu": {
+			Err: itunes.ErrNoFeed,
+		},
+	}
+
+	for path, exp := range data {
+
+		f, err := os.Open(filepath.Join("testdata", path))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		feed, err := itunes.ParseHTML(f)
+		f.Close()
+
+		if !equalErrors(err, exp.Err) {
+			t.Errorf("%s: expected error %s, got %s", path, formatError(exp.Err), formatError(err))
+		}
+
+		if feed != exp.Feed {
+			t.Errorf("%s: expected feed %q, got %q", path, exp.Feed, feed)
+		}
+	}
+}
+
+func TestPageKind(t *testing.T) {
+
+	audiobook := `<html><body><a twitter-content-url="https://itunes.apple.com/us/audiobook/dune/id1234?mt=3">Share</a></body></html>`
+
+	data := map[string]struct {
+		Body io.Reader
+		Kind error
+	}{
+		"iTunes U": {
+			Body: openFile(t, "errors/no-feed/itunes-itunesu"),
+			Kind: itunes.ErrITunesU,
+		},
+		"no episodes": {
+			Body: openFile(t, "errors/no-feed/itunes-no-episodes"),
+		},
+		"audiobook": {
+			Body: strings.NewReader(audiobook),
+			Kind: itunes.ErrAudiobook,
+		},
+	}
+
+	for name, exp := range data {
+
+		_, err := itunes.ParseHTML(exp.Body)
+
+		var e *itunes.NoFeedError
+		if !errors.As(err, &e) {
+			t.Errorf("%s: expected a NoFeedError, got %s", name, formatError(err))
+			continue
+		}
+
+		if e.Kind != exp.Kind {
+			t.Errorf("%s: expected kind %v, got %v", name, exp.Kind, e.Kind)
+		}
+
+		if exp.Kind != nil && !errors.Is(err, exp.Kind) {
+			t.Errorf("%s: expected error to match %v", name, exp.Kind)
+		}
+
+		if !errors.Is(err, itunes.ErrNoFeed) {
+			t.Errorf("%s: expected error to match ErrNoFeed", name)
+		}
+	}
+}
+
+func TestInterstitial(t *testing.T) {
+
+	const feed = "http://feeds.serialpodcast.org/serialpodcast"
+
+	page := `<html><head><meta http-equiv="Refresh" content="0; URL=itmss://itunes.apple.com/us/podcast/serial/id917918570?mt=2"></head><body>Opening Podcasts...</body></html>`
+	next := "https://itunes.apple.com/us/podcast/serial/id917918570?mt=2"
+
+	_, err := itunes.ParseHTML(strings.NewReader(page))
+
+	var e *itunes.InterstitialError
+	if !errors.As(err, &e) {
+		t.Fatalf("expected an InterstitialError, got %s", formatError(err))
+	}
+
+	if e.URL != next {
+		t.Errorf("expected continuation link %q, got %q", next, e.URL)
+	}
+
+	if !errors.Is(err, itunes.ErrInterstitial) || errors.Is(err, itunes.ErrNoFeed) {
+		t.Errorf("expected error to match ErrInterstitial only, got %s", formatError(err))
+	}
+
+	// Refreshes to other hosts aren't followed.
+	other := strings.Replace(page, "itunes.apple.com", "example.com", 1)
+	if _, err := itunes.ParseHTML(strings.NewReader(other)); !errors.Is(err, itunes.ErrNoFeed) {
+		t.Errorf("expected ErrNoFeed for a non-Apple refresh, got %s", formatError(err))
+	}
+
+	// The ToRSS functions follow the link.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/us/app/open", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, page)
+	})
+	mux.HandleFunc("/us/podcast/serial/id917918570", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write(itunestest.Page("Serial", feed))
+	})
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	got, err := itunes.ToRSSClient("https://itunes.apple.com/us/app/open", redirectRequests(ts, http.DefaultClient))
+	if err != nil {
+		t.Fatalf("unexpected error %s", formatError(err))
+	}
+
+	if got != feed {
+		t.Errorf("expected feed %q, got %q", feed, got)
+	}
+}
+
+func openFile(t *testing.T, path string) io.Reader {
+
+	b, err := ioutil.ReadFile(filepath.Join("testdata", path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bytes.NewReader(b)
+}
+
+func TestParseHTMLFragments(t *testing.T) {
+
+	data := map[string]struct {
+		HTML string
+		Feed string
+		Err  error
+	}{
+		"no marker": {
+			HTML: `<html><body><button>Subscribe</button></body></html>`,
+			Err:  itunes.ErrNoFeed,
+		},
+		"marker in text": {
+			HTML: `<html><body><p>Every button has a feed-url.</p></body></html>`,
+			Err:  itunes.ErrNoFeed,
+		},
+		"marker in text before button": {
+			HTML: `<html><body><p>Look for the feed-url.</p><button feed-url="http://example.com/feed">Subscribe</button></body></html>`,
+			Feed: "http://example.com/feed",
+		},
+		"marker in another tag": {
+			HTML: `<html><body><a feed-url="x">Link</a><button class="b" feed-url="http://example.com/feed">Subscribe</button></body></html>`,
+			Feed: "http://example.com/feed",
+		},
+		"mobile page": {
+			HTML: `<html><body><div class="actions"><a class="subscribe" data-feed-url="http://example.com/feed">Subscribe</a></div></body></html>`,
+			Feed: "http://example.com/feed",
+		},
+		"anchor": {
+			HTML: `<html><body><a class="subscribe" feed-url="http://example.com/feed">Subscribe</a></body></html>`,
+			Feed: "http://example.com/feed",
+		},
+		"data-feedurl attribute": {
+			HTML: `<html><body><div data-feedurl="http://example.com/feed"></div></body></html>`,
+			Feed: "http://example.com/feed",
+		},
+		"data-feed attribute": {
+			HTML: `<html><body><p>Our feed</p><span data-feed="http://example.com/feed"></span></body></html>`,
+			Feed: "http://example.com/feed",
+		},
+		"AMP page": {
+			HTML: `<html amp><head><link rel="canonical" href="https://podcasts.apple.com/us/podcast/id1"><link rel="alternate" type="application/rss+xml" href="http://example.com/feed"></head><body></body></html>`,
+			Feed: "http://example.com/feed",
+		},
+		"server data page": {
+			HTML: `<html><body><script type="application/json" id="serialized-server-data">[{"intent":{"id":"1"},"data":{"shelves":[{"contentType":"showHeaderRegular","items":[{"title":"Show","contextAction":{"podcastOffer":{"feedUrl":"http://example.com/feed"}}}]}]}}]</script></body></html>`,
+			Feed: "http://example.com/feed",
+		},
+		"server data page without a feed": {
+			HTML: `<html><body><script type="application/json" id="serialized-server-data">[{"data":{"shelves":[]}}]</script></body></html>`,
+			Err:  itunes.ErrNoFeed,
+		},
+		"shoebox page": {
+			HTML: `<html><body><script type="fastboot/shoebox" id="shoebox-media-api-cache-amp-podcasts">{"catalog.us.podcasts.1":"{\"d\":[{\"id\":\"1\",\"type\":\"podcasts\",\"attributes\":{\"name\":\"Show\",\"feedUrl\":\"http://example.com/feed\"}}]}"}</script></body></html>`,
+			Feed: "http://example.com/feed",
+		},
+		"shoebox page without a feed": {
+			HTML: `<html><body><script type="fastboot/shoebox" id="shoebox-global-elements">{"nav":"{\"items\":[]}"}</script></body></html>`,
+			Err:  itunes.ErrNoFeed,
+		},
+		"AMP page with a non-RSS link": {
+			HTML: `<html amp><head><link rel="stylesheet" type="application/rss+xml" href="http://example.com/style"></head><body></body></html>`,
+			Err:  itunes.ErrNoFeed,
+		},
+	}
+
+	for name, exp := range data {
+
+		feed, err := itunes.ParseHTML(strings.NewReader(exp.HTML))
+
+		if !equalErrors(err, exp.Err) {
+			t.Errorf("%s: expected error %s, got %s", name, formatError(exp.Err), formatError(err))
+		}
+
+		if feed != exp.Feed {
+			t.Errorf("%s: expected feed %q, got %q", name, exp.Feed, feed)
+		}
+	}
+}
+
+func TestParseHTMLFeeds(t *testing.T) {
+
+	const (
+		old = "http://example.com/old-feed"
+		new = "http://example.com/feed"
+	)
+
+	page := `<html><head><link rel="alternate" type="application/rss+xml" href="` + new + `"></head><body>` +
+		`<button feed-url="` + old + `">Subscribe</button>` +
+		`<script type="application/json" id="serialized-server-data">[{"data":{"feedUrl":"` + new + `"}}]</script>` +
+		`<script type="fastboot/shoebox" id="shoebox">{"podcast":"{\"feedUrl\":\"` + new + `\"}"}</script>` +
+		`</body></html>`
+
+	matches, err := itunes.ParseHTMLFeeds(strings.NewReader(page))
+	if err != nil {
+		t.Fatalf("unexpected error %s", formatError(err))
+	}
+
+	exp := []itunes.FeedMatch{
+		{
+			URL:        new,
+			Strategies: []string{"RSS link", "serialized server data", "shoebox JSON"},
+			Confidence: 0.6,
+		},
+		{
+			URL:        old,
+			Strategies: []string{"feed-url attribute", "feed attribute"},
+			Confidence: 0.4,
+		},
+	}
+
+	if got, want := fmt.Sprintf("%+v", matches), fmt.Sprintf("%+v", exp); got != want {
+		t.Errorf("expected matches\n%s\ngot\n%s", want, got)
+	}
+
+	// ParseHTML takes the first strategy's feed regardless.
+	if feed, _ := itunes.ParseHTML(strings.NewReader(page)); feed != old {
+		t.Errorf("expected ParseHTML to return %q, got %q", old, feed)
+	}
+
+	_, err = itunes.ParseHTMLFeeds(strings.NewReader(`<html><body></body></html>`))
+
+	var e *itunes.NoFeedError
+	if !errors.As(err, &e) || len(e.Errors) != 5 {
+		t.Errorf("expected a NoFeedError with 5 strategy errors, got %s", formatError(err))
+	}
+}
+
+func TestParsePlist(t *testing.T) {
+
+	data := map[string]struct {
+		URL string
+		Err error
+	}{
+		"podcasts/serial/plist": {
+			URL: "podcasts/serial/itunes-page",
+		},
+		"podcasts/s-town/plist-1": {
+			URL: "podcasts/s-town/itunes-page?cc=mx&l=en&urlDesc=%2Fs-town&mt=2&id=1212558767",
+		},
+		"errors/no-feed/plist-item-not-available": {
+			Err: itunes.ErrNoFeed,
+		},
+	}
+
+	for path, exp := range data {
+
+		f, err := os.Open(filepath.Join("testdata", path))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		target, err := itunes.ParsePlist(f)
+		f.Close()
+
+		if !equalErrors(err, exp.Err) {
+			t.Errorf("%s: expected error %s, got %s", path, formatError(exp.Err), formatError(err))
+		}
+
+		if target.URL != exp.URL {
+			t.Errorf("%s: expected URL %q, got %q", path, exp.URL, target.URL)
+		}
+	}
+}
+
+func TestToRSSReader(t *testing.T) {
+
+	data := []struct {
+		Path  string
+		CType string
+		Feed  string
+		Next  string
+		Err   error
+	}{
+		{
+			Path:  "podcasts/serial/itunes-page",
+			CType: "text/html; charset=utf-8",
+			Feed:  "http://feeds.serialpodcast.org/serialpodcast",
+		},
+		{
+			Path:  "podcasts/serial/plist",
+			CType: "text/xml",
+			Next:  "podcasts/serial/itunes-page",
+		},
+		{
+			Path:  "podcasts/s-town/plist-2",
+			CType: "application/xml; charset=utf-8",
+			Next:  "podcasts/s-town/plist-1",
+		},
+		{
+			Path:  "errors/no-feed/itunes-no-episodes",
+			CType: "text/html",
+			Err:   itunes.ErrNoFeed,
+		},
+		{
+			Path:  "podcasts/serial/itunes-page",
+			CType: "application/xhtml+xml; charset=utf-8",
+			Feed:  "http://feeds.serialpodcast.org/serialpodcast",
+		},
+		{
+			Path:  "podcasts/serial/itunes-page",
+			CType: "image/png",
+			Err:   errors.New(`unsupported Content Type "image/png"`),
+		},
+	}
+
+	for _, test := range data {
+
+		f, err := os.Open(filepath.Join("testdata", test.Path))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		feed, next, err := itunes.ToRSSReader(f, test.CType)
+		f.Close()
+
+		if !equalErrors(err, test.Err) {
+			t.Errorf("%s (%s): expected error %s, got %s", test.Path, test.CType, formatError(test.Err), formatError(err))
+		}
+
+		if feed != test.Feed {
+			t.Errorf("%s (%s): expected feed %q, got %q", test.Path, test.CType, test.Feed, feed)
+		}
+
+		if next != test.Next {
+			t.Errorf("%s (%s): expected next URL %q, got %q", test.Path, test.CType, test.Next, next)
+		}
+	}
+}
+
+func TestToRSSFile(t *testing.T) {
+
+	data := map[string]struct {
+		Feed string
+		Next string
+		Err  error
+	}{
+		"podcasts/wittertainment/itunes-page": {
+			Feed: "https://podcasts.files.bbci.co.uk/b00lvdrj.rss",
+		},
+		"podcasts/wittertainment/plist": {
+			Next: "podcasts/wittertainment/itunes-page?cc=gb&urlDesc=%2Fkermode-and-mayos-film-review&mt=2&id=73802698",
+		},
+		"errors/no-feed/plist-incomplete": {
+			Err: itunes.ErrNoFeed,
+		},
+	}
+
+	for path, exp := range data {
+
+		feed, next, err := itunes.ToRSSFile(filepath.Join("testdata", path))
+
+		if !equalErrors(err, exp.Err) {
+			t.Errorf("%s: expected error %s, got %s", path, formatError(exp.Err), formatError(err))
+		}
+
+		if feed != exp.Feed {
+			t.Errorf("%s: expected feed %q, got %q", path, exp.Feed, feed)
+		}
+
+		if next != exp.Next {
+			t.Errorf("%s: expected next URL %q, got %q", path, exp.Next, next)
+		}
+	}
+
+	_, _, err := itunes.ToRSSFile(filepath.Join("testdata", "no-such-file"))
+	if !os.IsNotExist(err) {
+		t.Errorf("missing file: expected a not-exist error, got %s", formatError(err))
+	}
+}
+
 func TestBadURL(t *testing.T) {
 
 	urls := []string{
@@ -162,12 +786,20 @@ func TestBadURL(t *testing.T) {
 			err = e.Err
 		}
 
-		exp := fmt.Errorf("fetch error: bad URL: %s", err)
+		exp := &itunes.URLError{
+			URL: u,
+			Err: &itunes.BadURLError{URL: u, Err: err},
+		}
 		_, got := itunes.ToRSS(u)
 
 		if !equalErrors(got, exp) {
 			t.Errorf("URL %q: expected error %s, got %s", u, formatError(exp), formatError(got))
 		}
+
+		var e *itunes.BadURLError
+		if !errors.As(got, &e) || e.URL != u || e.Err.Error() != err.Error() {
+			t.Errorf("URL %q: expected a BadURLError, got %s", u, formatError(got))
+		}
 	}
 }
 
@@ -186,7 +818,9 @@ func TestClientError(t *testing.T) {
 			return nil, errors.New(s)
 		})
 
-		exp := fmt.Errorf("fetch error: %s", s)
+		exp := &itunes.URLError{
+			Err: fmt.Errorf("fetch error: %s", s),
+		}
 		_, got := itunes.ToRSSClient("", client)
 
 		if !equalErrors(got, exp) {
@@ -220,7 +854,9 @@ func TestBadHTTPStatus(t *testing.T) {
 			msg = fmt.Sprintf("status code %d", code) // Go's default status for unrecognised error codes
 		}
 
-		exp := fmt.Errorf("fetch error: %d %s", code, msg)
+		exp := &itunes.URLError{
+			Err: fmt.Errorf("fetch error: %d %s", code, msg),
+		}
 		_, got := itunes.ToRSSClient("", client)
 
 		if !equalErrors(got, exp) {
@@ -231,6 +867,38 @@ func TestBadHTTPStatus(t *testing.T) {
 	}
 }
 
+func TestBlocked(t *testing.T) {
+
+	data := map[string]http.Handler{
+		"403 Forbidden": errorHandler(http.StatusForbidden),
+		"CAPTCHA page": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><body><div class="g-recaptcha"></div></body></html>`)
+		}),
+	}
+
+	for name, h := range data {
+
+		ts := httptest.NewServer(h)
+
+		_, err := itunes.ToRSSClient("", redirectRequests(ts, http.DefaultClient))
+		if !errors.Is(err, itunes.ErrBlocked) {
+			t.Errorf("%s: expected ErrBlocked, got %s", name, formatError(err))
+		}
+
+		ts.Close()
+	}
+
+	// Other errors aren't blocks.
+	ts := httptest.NewServer(errorHandler(http.StatusNotFound))
+	defer ts.Close()
+
+	_, err := itunes.ToRSSClient("", redirectRequests(ts, http.DefaultClient))
+	if errors.Is(err, itunes.ErrBlocked) {
+		t.Errorf("404 Not Found: unexpected ErrBlocked")
+	}
+}
+
 func TestBadContentType(t *testing.T) {
 
 	types := []string{
@@ -255,13 +923,20 @@ func TestBadContentType(t *testing.T) {
 		ts := httptest.NewServer(contentTypeHandler(ctype))
 		client := redirectRequests(ts, http.DefaultClient)
 
-		exp := fmt.Errorf("bad Content Type %q: %s", ctype, err)
+		exp := &itunes.URLError{
+			Err: fmt.Errorf("bad Content Type %q: %s", ctype, err),
+		}
 		_, got := itunes.ToRSSClient("", client)
 
 		if !equalErrors(got, exp) {
 			t.Errorf("Content Type %q: expected error %s, got %s", ctype, formatError(exp), formatError(got))
 		}
 
+		var e *itunes.MalformedContentTypeError
+		if !errors.As(got, &e) || e.ContentType != ctype {
+			t.Errorf("Content Type %q: expected a MalformedContentTypeError, got %s", ctype, formatError(got))
+		}
+
 		ts.Close()
 	}
 }
@@ -279,17 +954,125 @@ func TestUnsupportedContentType(t *testing.T) {
 		ts := httptest.NewServer(contentTypeHandler(ctype))
 		client := redirectRequests(ts, http.DefaultClient)
 
-		exp := fmt.Errorf("unsupported Content Type %q", ctype)
+		exp := &itunes.URLError{
+			Err: fmt.Errorf("unsupported Content Type %q", ctype),
+		}
 		_, got := itunes.ToRSSClient("", client)
 
 		if !equalErrors(got, exp) {
 			t.Errorf("Content Type %q: expected error %s, got %s", ctype, formatError(exp), formatError(got))
 		}
 
+		media, _, _ := mime.ParseMediaType(ctype)
+
+		var e *itunes.UnexpectedContentTypeError
+		if !errors.As(got, &e) || e.MediaType != media || e.URL != "" {
+			t.Errorf("Content Type %q: expected an UnexpectedContentTypeError for %q, got %s", ctype, media, formatError(got))
+		}
+
 		ts.Close()
 	}
 }
 
+func TestCaptcha(t *testing.T) {
+
+	itunesU := `<html><body><a twitter-content-url="https://itunes.apple.com/us/itunes-u/security/id1234">Share</a><p>Lecture 3: Breaking CAPTCHAs</p></body></html>`
+
+	data := map[string]struct {
+		Body string
+		Err  error
+	}{
+		"recaptcha widget": {
+			Body: `<html><body><div class="g-recaptcha" data-sitekey="x"></div></body></html>`,
+			Err:  itunes.ErrBlocked,
+		},
+		"captcha script": {
+			Body: `<html><head><script src="https://www.google.com/recaptcha/api.js"></script></head></html>`,
+			Err:  itunes.ErrBlocked,
+		},
+		"captcha iframe": {
+			Body: `<html><body><iframe src="https://newassets.hcaptcha.com/captcha/v1/frame"></iframe></body></html>`,
+			Err:  itunes.ErrBlocked,
+		},
+		"captcha form": {
+			Body: `<html><body><form action="/Captcha/verify"><input name="answer"></form></body></html>`,
+			Err:  itunes.ErrBlocked,
+		},
+		// Pages that only mention CAPTCHAs aren't blocks.
+		"text": {
+			Body: `<p>captcha</p>`,
+			Err:  itunes.ErrNoFeed,
+		},
+		"link": {
+			Body: `<html><body><a href="https://example.com/captcha">About CAPTCHAs</a></body></html>`,
+			Err:  itunes.ErrNoFeed,
+		},
+		"iTunes U": {
+			Body: itunesU,
+			Err:  itunes.ErrITunesU,
+		},
+	}
+
+	for name, exp := range data {
+
+		_, err := itunes.ParseHTML(strings.NewReader(exp.Body))
+
+		if !errors.Is(err, exp.Err) {
+			t.Errorf("%s: expected error %s, got %s", name, formatError(exp.Err), formatError(err))
+		}
+
+		if exp.Err != itunes.ErrBlocked && errors.Is(err, itunes.ErrBlocked) {
+			t.Errorf("%s: unexpected ErrBlocked", name)
+		}
+	}
+}
+
+func TestSniffPlainText(t *testing.T) {
+
+	sniff := itunes.Options{SniffPlainText: true}
+
+	data := map[string]struct {
+		Feed string
+		Next string
+	}{
+		"podcasts/serial/itunes-page": {
+			Feed: "http://feeds.serialpodcast.org/serialpodcast",
+		},
+		"podcasts/serial/plist": {
+			Next: "podcasts/serial/itunes-page",
+		},
+	}
+
+	for path, exp := range data {
+
+		b, err := ioutil.ReadFile(filepath.Join("testdata", path))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, _, err = itunes.ToRSSReader(bytes.NewReader(b), "text/plain")
+		if _, ok := err.(*itunes.UnexpectedContentTypeError); !ok {
+			t.Errorf("%s: expected an UnexpectedContentTypeError, got %s", path, formatError(err))
+		}
+
+		feed, next, err := sniff.ToRSSReader(bytes.NewReader(b), "text/plain; charset=utf-8")
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", path, formatError(err))
+			continue
+		}
+
+		if feed != exp.Feed || next != exp.Next {
+			t.Errorf("%s: expected feed %q and next %q, got %q and %q", path, exp.Feed, exp.Next, feed, next)
+		}
+	}
+
+	// Other text is still unsupported.
+	_, _, err := sniff.ToRSSReader(strings.NewReader("Not found"), "text/plain")
+	if _, ok := err.(*itunes.UnexpectedContentTypeError); !ok {
+		t.Errorf("expected an UnexpectedContentTypeError, got %s", formatError(err))
+	}
+}
+
 func contentTypeHandler(typ string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", typ)
@@ -359,7 +1142,377 @@ func equalErrors(err1, err2 error) bool {
 		return true
 	case err1 == nil, err2 == nil:
 		return false
+	case errors.Is(err1, err2):
+		return true
 	default:
 		return err1.Error() == err2.Error()
 	}
 }
+
+func TestParseHTMLTooLarge(t *testing.T) {
+
+	// An endless page must not be read into memory.
+	_, err := itunes.ParseHTML(endlessReader{})
+	if err != itunes.ErrTooLarge {
+		t.Errorf("expected error %s, got %s", formatError(itunes.ErrTooLarge), formatError(err))
+	}
+
+	page, err := ioutil.ReadFile(filepath.Join("testdata", "podcasts/serial/itunes-page"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := map[int64]error{
+		int64(len(page)):     nil,
+		int64(len(page)) - 1: itunes.ErrTooLarge,
+	}
+
+	for size, exp := range data {
+
+		opts := itunes.Options{MaxPageSize: size}
+
+		_, err := opts.ParseHTML(bytes.NewReader(page))
+		if !equalErrors(err, exp) {
+			t.Errorf("MaxPageSize %d: expected error %s, got %s", size, formatError(exp), formatError(err))
+		}
+	}
+}
+
+func TestParsePlistVariants(t *testing.T) {
+
+	const next = "https://itunes.apple.com/us/podcast/id1?mt=2&l=en"
+
+	data := map[string]string{
+		"whitespace": `<?xml version="1.0"?>
+<plist version="1.0">
+<dict>
+  <key>action</key>
+  <dict>
+    <key> kind </key>
+    <string>Goto</string>
+    <key>url</key>
+    <string>
+      https://itunes.apple.com/us/podcast/id1?mt=2&amp;l=en
+    </string>
+  </dict>
+</dict>
+</plist>`,
+		"url before kind": `<plist version="1.0"><dict><key>action</key><dict><key>url</key><string>https://itunes.apple.com/us/podcast/id1?mt=2&amp;l=en</string><key>kind</key><string>Goto</string></dict></dict></plist>`,
+		"case":            `<plist><dict><key>Kind</key><string>goto</string><key>URL</key><string>https://itunes.apple.com/us/podcast/id1?mt=2&amp;l=en</string></dict></plist>`,
+		"attributes":      `<plist version='1.0' xmlns="http://www.apple.com/itms/"><dict id="action"><key>kind</key><string xml:space="preserve">Goto</string><key>url</key><string>https://itunes.apple.com/us/podcast/id1?mt=2&amp;l=en</string></dict></plist>`,
+		"truncated":       `<plist><dict><key>action</key><dict><key>kind</key><string>Goto</string><key>url</key><string>https://itunes.apple.com/us/podcast/id1?mt=2&amp;l=en</string>`,
+	}
+
+	for name, plist := range data {
+
+		target, err := itunes.ParsePlist(strings.NewReader(plist))
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", name, formatError(err))
+			continue
+		}
+
+		if target.URL != next {
+			t.Errorf("%s: expected URL %q, got %q", name, next, target.URL)
+		}
+	}
+
+	// A url in a dict without a Goto kind isn't an action.
+	plist := `<plist><dict><key>kind</key><string>Goto</string><key>action</key><dict><key>url</key><string>https://itunes.apple.com/</string></dict></dict></plist>`
+	if _, err := itunes.ParsePlist(strings.NewReader(plist)); !errors.Is(err, itunes.ErrNoFeed) {
+		t.Errorf("expected ErrNoFeed, got %s", formatError(err))
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+
+	page, err := ioutil.ReadFile(filepath.Join("testdata", "podcasts/serial/itunes-page"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plist, err := ioutil.ReadFile(filepath.Join("testdata", "podcasts/serial/plist"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Readers that fail halfway through.
+	truncated := func(b []byte, err error) io.Reader {
+		return io.MultiReader(bytes.NewReader(b[:len(b)/2]), iotest.ErrReader(err))
+	}
+
+	reset := errors.New("connection reset by peer")
+
+	data := map[string]struct {
+		Parse func(io.Reader) error
+		Body  []byte
+		Err   error
+		Exp   error
+	}{
+		"page, unexpected EOF": {
+			Parse: func(r io.Reader) error { _, err := itunes.ParseHTML(r); return err },
+			Body:  page,
+			Err:   io.ErrUnexpectedEOF,
+			Exp:   itunes.ErrTruncated,
+		},
+		"plist, unexpected EOF": {
+			Parse: func(r io.Reader) error { _, err := itunes.ParsePlist(r); return err },
+			Body:  plist,
+			Err:   io.ErrUnexpectedEOF,
+			Exp:   itunes.ErrTruncated,
+		},
+		"plist, connection reset": {
+			Parse: func(r io.Reader) error { _, err := itunes.ParsePlist(r); return err },
+			Body:  plist,
+			Err:   reset,
+			Exp:   reset,
+		},
+	}
+
+	for name, test := range data {
+
+		err := test.Parse(truncated(test.Body, test.Err))
+
+		var e *itunes.ReadError
+		if !errors.As(err, &e) || !errors.Is(err, test.Exp) {
+			t.Errorf("%s: expected a ReadError matching %s, got %s", name, formatError(test.Exp), formatError(err))
+		}
+
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, itunes.ErrNoFeed) {
+			t.Errorf("%s: unexpected error %s", name, formatError(err))
+		}
+	}
+}
+
+func TestBufferSizes(t *testing.T) {
+
+	plist, err := ioutil.ReadFile(filepath.Join("testdata", "podcasts/serial/plist"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := itunes.Options{MaxPlistLine: 16}
+	_, err = opts.ParsePlist(bytes.NewReader(plist))
+	if !errors.Is(err, bufio.ErrTooLong) || !errors.Is(err, itunes.ErrNoFeed) {
+		t.Errorf("expected a NoFeedError matching bufio.ErrTooLong, got %s", formatError(err))
+	}
+
+	opts.MaxPlistLine = 1 << 10
+	if _, err := opts.ParsePlist(bytes.NewReader(plist)); err != nil {
+		t.Errorf("unexpected error %s", formatError(err))
+	}
+
+	// Small read buffers still leave room to detect the
+	// Content Type.
+	opts.ReadBufferSize = 16
+	feed, _, err := opts.ToRSSFile(filepath.Join("testdata", "podcasts/serial/itunes-page"))
+	if err != nil {
+		t.Fatalf("unexpected error %s", formatError(err))
+	}
+
+	if exp := "http://feeds.serialpodcast.org/serialpodcast"; feed != exp {
+		t.Errorf("expected feed %q, got %q", exp, feed)
+	}
+}
+
+// An endlessReader is an infinite stream of spaces.
+type endlessReader struct{}
+
+func (endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = ' '
+	}
+	return len(p), nil
+}
+
+func TestParseHTMLAllocs(t *testing.T) {
+
+	if raceEnabled {
+		t.Skip("the race detector drops pooled buffers")
+	}
+
+	body, err := ioutil.ReadFile(filepath.Join("testdata", "podcasts/serial/itunes-page"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Pages are read into pooled buffers, so the allocations
+	// shouldn't depend on the size of the page.
+	const maxAllocs = 20
+
+	allocs := testing.AllocsPerRun(100, func() {
+		itunes.ParseHTML(bytes.NewReader(body))
+	})
+
+	if allocs > maxAllocs {
+		t.Errorf("expected at most %d allocations, got %.0f", maxAllocs, allocs)
+	}
+}
+
+func BenchmarkParseHTML(b *testing.B) {
+
+	paths := map[string]string{
+		"feed":    "podcasts/serial/itunes-page",
+		"no feed": "errors/no-feed/itunes-itunesu",
+	}
+
+	for name, path := range paths {
+
+		body, err := ioutil.ReadFile(filepath.Join("testdata", path))
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(body)))
+			for i := 0; i < b.N; i++ {
+				itunes.ParseHTML(bytes.NewReader(body))
+			}
+		})
+	}
+}
+
+func BenchmarkToRSSReader(b *testing.B) {
+
+	body, err := ioutil.ReadFile(filepath.Join("testdata", "podcasts/serial/itunes-page"))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(body)))
+	for i := 0; i < b.N; i++ {
+		itunes.ToRSSReader(bytes.NewReader(body), "text/html; charset=utf-8")
+	}
+}
+
+func BenchmarkParsePlist(b *testing.B) {
+
+	body, err := ioutil.ReadFile(filepath.Join("testdata", "podcasts/serial/plist"))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(body)))
+	for i := 0; i < b.N; i++ {
+		itunes.ParsePlist(bytes.NewReader(body))
+	}
+}
+
+func BenchmarkToRSS(b *testing.B) {
+
+	page, err := ioutil.ReadFile(filepath.Join("testdata", "podcasts/serial/itunes-page"))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	mux.HandleFunc("/plist", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write(itunestest.GotoPlist(ts.URL + "/page"))
+	})
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write(page)
+	})
+
+	client := &http.Client{
+		Transport: &http.Transport{},
+	}
+
+	// A Goto plist followed by a page.
+	u := ts.URL + "/plist"
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := itunes.ToRSSClient(u, client); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestRedirectResult(t *testing.T) {
+
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	mux.Handle("/old", http.RedirectHandler("/page", http.StatusMovedPermanently))
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write(itunestest.Page("", "http://example.com/feed"))
+	})
+
+	// By default, the client follows the redirect.
+	feed, err := itunes.ToRSSClient(ts.URL+"/old", http.DefaultClient)
+	if err != nil || feed != "http://example.com/feed" {
+		t.Errorf("expected feed, got %q (error %s)", feed, formatError(err))
+	}
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	_, err = itunes.ToRSSClient(ts.URL+"/old", client)
+
+	var r *itunes.RedirectResult
+	if !errors.As(err, &r) {
+		t.Fatalf("expected a RedirectResult, got %s", formatError(err))
+	}
+
+	exp := itunes.RedirectResult{
+		URL:        ts.URL + "/old",
+		Location:   ts.URL + "/page",
+		StatusCode: http.StatusMovedPermanently,
+	}
+
+	if *r != exp {
+		t.Errorf("expected %+v, got %+v", exp, *r)
+	}
+}
+
+func TestHarvestFeeds(t *testing.T) {
+
+	page := `<html><body>
+<a href="https://itunes.apple.com/us/podcast/rss/id1">iTunes</a>
+<a href="https://example.com/about">About</a>
+<a href="https://example.com/podcast/episodes">Episodes</a>
+<a href="https://example.com/podcast.rss">RSS</a>
+<p>Subscribe at http://show.libsyn.com/rss or http://feeds.feedburner.com/show.</p>
+<a href="https://example.com/podcast.rss">RSS again</a>
+</body></html>`
+
+	exp := []string{
+		"http://show.libsyn.com/rss",
+		"http://feeds.feedburner.com/show",
+		"https://example.com/podcast.rss",
+	}
+
+	for _, harvest := range []bool{false, true} {
+
+		opts := itunes.Options{HarvestFeeds: harvest}
+
+		feed, err := opts.ParseHTML(strings.NewReader(page))
+
+		var e *itunes.NoFeedError
+		if !errors.As(err, &e) {
+			t.Errorf("harvest %t: expected a NoFeedError, got feed %q, error %s", harvest, feed, formatError(err))
+			continue
+		}
+
+		want := exp
+		if !harvest {
+			want = nil
+		}
+
+		if got := strings.Join(e.Candidates, " "); got != strings.Join(want, " ") {
+			t.Errorf("harvest %t: expected candidates %v, got %v", harvest, want, e.Candidates)
+		}
+	}
+}
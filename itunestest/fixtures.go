@@ -0,0 +1,127 @@
+package itunestest
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+)
+
+// Page returns a minimal iTunes HTML page for a show. If feed
+// is empty, the page has no feed, like the page for an iTunesU
+// course.
+func Page(title, feed string) []byte {
+
+	button := ""
+	if feed != "" {
+		button = fmt.Sprintf(`<button kind="podcast" feed-url="%s">Subscribe</button>`, html.EscapeString(feed))
+	}
+
+	return []byte(fmt.Sprintf(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8" />
+<title>%s</title>
+</head>
+<body>
+<h1>%s</h1>
+%s
+</body>
+</html>
+`, html.EscapeString(title), html.EscapeString(title), button))
+}
+
+// GotoPlist returns an iTunes plist that redirects to url.
+func GotoPlist(url string) []byte {
+	return []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<plist version="1.0">
+<dict>
+<key>pings</key>
+<array></array>
+<key>jingleDocType</key><string></string>
+<key>jingleAction</key><string></string>
+<key>action</key>
+<dict>
+<key>kind</key><string>Goto</string>
+<key>url</key><string>%s</string>
+</dict>
+</dict>
+</plist>
+`, html.EscapeString(url)))
+}
+
+// ItemNotAvailablePlist returns the plist that iTunes serves
+// for shows that aren't available in the store.
+func ItemNotAvailablePlist() []byte {
+	return []byte(itemNotAvailablePlist)
+}
+
+const itemNotAvailablePlist = `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<plist version="1.0">
+<dict>
+<key>pings</key>
+<array></array>
+<key>failureType</key><string></string>
+<key>customerTitleMessage</key><string>Item Not Available</string>
+<key>customerMessage</key><string>The item you&#39;ve requested is not currently available in the U.S. store.</string>
+<key>m-allowed</key><false/>
+<key>dialog</key>
+<dict><key>m-allowed</key><false/>
+<key>message</key><string>Item Not Available</string>
+<key>explanation</key><string>The item you&#39;ve requested is not currently available in the U.S. store.</string>
+<key>defaultButton</key><string>ok</string>
+<key>okButtonString</key><string>OK</string>
+<key>initialCheckboxValue</key><true/></dict>
+</dict>
+</plist>
+`
+
+// A Fixture is a synthetic iTunes response.
+type Fixture struct {
+	Path        string
+	ContentType string
+	Body        []byte
+}
+
+// Chain returns depth Goto plists followed by a page containing
+// feed. Each plist redirects to the next fixture in the chain,
+// at baseURL plus the fixture's Path. The chain starts at the
+// first fixture.
+func Chain(baseURL, feed string, depth int) []Fixture {
+
+	fixtures := make([]Fixture, depth+1)
+
+	for i := 0; i < depth; i++ {
+		fixtures[i].Path = fmt.Sprintf("/plist-%d", i+1)
+		fixtures[i].ContentType = contentXML
+	}
+
+	page := &fixtures[depth]
+	page.Path = "/page"
+	page.ContentType = contentHTML
+	page.Body = Page("", feed)
+
+	for i := 0; i < depth; i++ {
+		fixtures[i].Body = GotoPlist(baseURL + fixtures[i+1].Path)
+	}
+
+	return fixtures
+}
+
+// Handler returns an http.Handler that serves each fixture at
+// its Path.
+func Handler(fixtures ...Fixture) http.Handler {
+
+	m := map[string]Fixture{}
+	for _, f := range fixtures {
+		m[f.Path] = f
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, ok := m[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		serve(w, f.ContentType, f.Body)
+	})
+}
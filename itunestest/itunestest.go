@@ -0,0 +1,377 @@
+// Package itunestest provides a fake iTunes server, and a
+// Client that records and replays real responses, for testing
+// code that uses the itunes package.
+package itunestest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/deepilla/itunes"
+)
+
+// UserAgent is the User Agent that the server expects. Like
+// the real iTunes server, it serves a page without a feed to
+// requests that don't use it.
+const UserAgent = "iTunes/10.1"
+
+// A Response selects how the server responds to requests for
+// a show's page.
+type Response int
+
+const (
+	// ResponsePage serves an HTML page containing the feed.
+	ResponsePage Response = iota
+
+	// ResponseNoFeed serves an HTML page without a feed, like
+	// the pages for iTunesU courses or shows with no episodes.
+	ResponseNoFeed
+
+	// ResponseItemNotAvailable serves the plist that iTunes
+	// returns for shows that aren't available in the store.
+	ResponseItemNotAvailable
+)
+
+// A Show configures the server's responses for a single show.
+type Show struct {
+	// ID is the show's iTunes ID. The server responds to any
+	// path ending in /id<ID>, e.g. /us/podcast/serial/id917918570.
+	ID int64
+
+	// Title is the show's title, returned by the Lookup API.
+	Title string
+
+	// Feed is the show's RSS feed.
+	Feed string
+
+	// Artwork is the URL of the show's 600x600 artwork,
+	// returned by the Lookup API.
+	Artwork string
+
+	// Episodes are returned after the show by Lookup API
+	// requests for its ID with entity=podcastEpisode.
+	Episodes []Episode
+
+	// Response selects the response for the show's page.
+	Response Response
+
+	// Status, if set, is the HTTP status code returned for the
+	// show's page instead of a response.
+	Status int
+
+	// Hops is the number of Goto plists served before the
+	// show's page.
+	Hops int
+
+	// ArtistID and Artist identify the show's creator. Lookup
+	// API requests for the artist's ID with entity=podcast
+	// return the artist's shows.
+	ArtistID int64
+	Artist   string
+
+	// Storefronts, if set, are the country codes in which the
+	// Lookup API returns the show. Lookups without a country
+	// use the US store.
+	Storefronts []string
+}
+
+// An Episode is an episode of a Show.
+type Episode struct {
+	ID        int64
+	Title     string
+	Enclosure string // audio file
+}
+
+// A Server is a fake iTunes server. It serves show pages, Goto
+// plists for WebObjects viewPodcast URLs, and JSON from the
+// Lookup API at /lookup?id=<ID>[,<ID>...].
+type Server struct {
+	// URL is the base URL of the server.
+	URL string
+
+	srv   *httptest.Server
+	shows map[int64]Show
+}
+
+// NewServer starts and returns a new Server for the given
+// shows. The caller should call Close when finished.
+func NewServer(shows ...Show) *Server {
+
+	s := &Server{
+		shows: map[int64]Show{},
+	}
+
+	for _, show := range shows {
+		s.shows[show.ID] = show
+	}
+
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.srv.URL
+
+	return s
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Client returns an itunes.Client that sends every request to
+// the server, whatever its host. Use it to resolve real iTunes
+// URLs against the server.
+func (s *Server) Client() itunes.Client {
+	return clientFunc(func(req *http.Request) (*http.Response, error) {
+
+		u, err := url.Parse(s.URL)
+		if err != nil {
+			return nil, err
+		}
+
+		req.URL.Scheme = u.Scheme
+		req.URL.Host = u.Host
+
+		return http.DefaultClient.Do(req)
+	})
+}
+
+type clientFunc func(req *http.Request) (*http.Response, error)
+
+func (f clientFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+const (
+	lookupPath  = "/lookup"
+	contentHTML = "text/html; charset=utf-8"
+	contentXML  = "text/xml; charset=utf-8"
+	contentJSON = "text/javascript; charset=utf-8"
+)
+
+var (
+	rePagePath = regexp.MustCompile(`/id(\d+)$`)
+	reGotoPath = regexp.MustCompile(`^/WebObjects/[A-Za-z]+\.woa/wa/viewPodcast$`)
+)
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+
+	switch {
+	case r.URL.Path == lookupPath:
+		s.serveLookup(w, r)
+
+	case reGotoPath.MatchString(r.URL.Path):
+		s.serveGoto(w, r)
+
+	default:
+		m := rePagePath.FindStringSubmatch(r.URL.Path)
+		if m == nil {
+			http.NotFound(w, r)
+			return
+		}
+		s.servePage(w, r, m[1])
+	}
+}
+
+func (s *Server) servePage(w http.ResponseWriter, r *http.Request, id string) {
+
+	show, ok := s.show(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if show.Status != 0 && show.Status != http.StatusOK {
+		http.Error(w, http.StatusText(show.Status), show.Status)
+		return
+	}
+
+	if r.UserAgent() != UserAgent {
+		serve(w, contentHTML, Page(show.Title, ""))
+		return
+	}
+
+	if hop, _ := strconv.Atoi(r.URL.Query().Get("hop")); hop < show.Hops {
+		u := fmt.Sprintf("%s%s?hop=%d", s.URL, r.URL.Path, hop+1)
+		serve(w, contentXML, GotoPlist(u))
+		return
+	}
+
+	switch show.Response {
+	case ResponseNoFeed:
+		serve(w, contentHTML, Page(show.Title, ""))
+	case ResponseItemNotAvailable:
+		serve(w, contentXML, ItemNotAvailablePlist())
+	default:
+		serve(w, contentHTML, Page(show.Title, show.Feed))
+	}
+}
+
+func (s *Server) serveGoto(w http.ResponseWriter, r *http.Request) {
+
+	show, ok := s.show(r.URL.Query().Get("id"))
+	if !ok {
+		serve(w, contentXML, ItemNotAvailablePlist())
+		return
+	}
+
+	serve(w, contentXML, GotoPlist(fmt.Sprintf("%s/podcast/id%d", s.URL, show.ID)))
+}
+
+type lookupResult struct {
+	WrapperType    string `json:"wrapperType"`
+	Kind           string `json:"kind,omitempty"`
+	ArtistID       int64  `json:"artistId,omitempty"`
+	ArtistName     string `json:"artistName,omitempty"`
+	CollectionID   int64  `json:"collectionId,omitempty"`
+	TrackID        int64  `json:"trackId,omitempty"`
+	CollectionName string `json:"collectionName,omitempty"`
+	TrackName      string `json:"trackName,omitempty"`
+	FeedURL        string `json:"feedUrl,omitempty"`
+	ArtworkURL600  string `json:"artworkUrl600,omitempty"`
+	EpisodeURL     string `json:"episodeUrl,omitempty"`
+}
+
+func (show Show) lookupResult() lookupResult {
+	return lookupResult{
+		WrapperType:    "track",
+		Kind:           "podcast",
+		ArtistID:       show.ArtistID,
+		ArtistName:     show.Artist,
+		CollectionID:   show.ID,
+		TrackID:        show.ID,
+		CollectionName: show.Title,
+		TrackName:      show.Title,
+		FeedURL:        show.Feed,
+		ArtworkURL600:  show.Artwork,
+	}
+}
+
+type lookupResponse struct {
+	ResultCount int            `json:"resultCount"`
+	Results     []lookupResult `json:"results"`
+}
+
+func (s *Server) serveLookup(w http.ResponseWriter, r *http.Request) {
+
+	resp := lookupResponse{
+		Results: []lookupResult{},
+	}
+
+	country := strings.ToLower(r.URL.Query().Get("country"))
+	if country == "" {
+		country = "us"
+	}
+
+	entity := r.URL.Query().Get("entity")
+
+	for _, id := range strings.Split(r.URL.Query().Get("id"), ",") {
+
+		if entity == "podcast" {
+			resp.Results = append(resp.Results, s.artistResults(id, country)...)
+			continue
+		}
+
+		show, ok := s.show(id)
+		if !ok || show.Response == ResponseItemNotAvailable || !show.inStorefront(country) {
+			continue
+		}
+		resp.Results = append(resp.Results, show.lookupResult())
+
+		if entity == "podcastEpisode" {
+			for _, ep := range show.Episodes {
+				resp.Results = append(resp.Results, lookupResult{
+					WrapperType:    "podcastEpisode",
+					Kind:           "podcast-episode",
+					CollectionID:   show.ID,
+					TrackID:        ep.ID,
+					CollectionName: show.Title,
+					TrackName:      ep.Title,
+					EpisodeURL:     ep.Enclosure,
+				})
+			}
+		}
+	}
+	resp.ResultCount = len(resp.Results)
+
+	b, err := json.Marshal(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	serve(w, contentJSON, b)
+}
+
+// artistResults returns an artist followed by their shows, in
+// order of ID, like the Lookup API.
+func (s *Server) artistResults(id, country string) []lookupResult {
+
+	artistID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil
+	}
+
+	var shows []Show
+	for _, show := range s.shows {
+		if show.ArtistID == artistID && show.Response != ResponseItemNotAvailable && show.inStorefront(country) {
+			shows = append(shows, show)
+		}
+	}
+
+	if len(shows) == 0 {
+		return nil
+	}
+
+	sort.Slice(shows, func(i, j int) bool {
+		return shows[i].ID < shows[j].ID
+	})
+
+	results := []lookupResult{{
+		WrapperType: "artist",
+		ArtistID:    artistID,
+		ArtistName:  shows[0].Artist,
+	}}
+
+	for _, show := range shows {
+		results = append(results, show.lookupResult())
+	}
+
+	return results
+}
+
+func (show Show) inStorefront(country string) bool {
+
+	if len(show.Storefronts) == 0 {
+		return true
+	}
+
+	for _, sf := range show.Storefronts {
+		if strings.ToLower(sf) == country {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Server) show(id string) (Show, bool) {
+
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return Show{}, false
+	}
+
+	show, ok := s.shows[n]
+	return show, ok
+}
+
+func serve(w http.ResponseWriter, ctype string, body []byte) {
+	w.Header().Set("Content-Type", ctype)
+	w.Write(body)
+}
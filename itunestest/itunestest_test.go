@@ -0,0 +1,285 @@
+package itunestest_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/deepilla/itunes"
+	"github.com/deepilla/itunes/itunestest"
+)
+
+var shows = []itunestest.Show{
+	{
+		ID:    917918570,
+		Title: "Serial",
+		Feed:  "http://feeds.serialpodcast.org/serialpodcast",
+	},
+	{
+		ID:       1226554692,
+		Title:    "Political Ramblings",
+		Response: itunestest.ResponseNoFeed,
+	},
+	{
+		ID:       374004085,
+		Title:    "Unavailable",
+		Response: itunestest.ResponseItemNotAvailable,
+	},
+	{
+		ID:     1212558767,
+		Title:  "S-Town",
+		Feed:   "http://feeds.stownpodcast.org/stownpodcast",
+		Status: http.StatusServiceUnavailable,
+	},
+}
+
+func TestServer(t *testing.T) {
+
+	data := []struct {
+		URL  string
+		Feed string
+		Err  error
+	}{
+		{
+			URL:  "https://itunes.apple.com/us/podcast/serial/id917918570?mt=2",
+			Feed: "http://feeds.serialpodcast.org/serialpodcast",
+		},
+		{
+			URL:  "https://itunes.apple.com/WebObjects/DZR.woa/wa/viewPodcast?id=917918570",
+			Feed: "http://feeds.serialpodcast.org/serialpodcast",
+		},
+		{
+			URL: "https://itunes.apple.com/us/podcast/id1226554692",
+			Err: itunes.ErrNoFeed,
+		},
+		{
+			URL: "https://itunes.apple.com/us/podcast/id374004085",
+			Err: itunes.ErrNoFeed,
+		},
+		{
+			URL: "https://itunes.apple.com/WebObjects/DZR.woa/wa/viewPodcast?id=123",
+			Err: itunes.ErrNoFeed,
+		},
+	}
+
+	s := itunestest.NewServer(shows...)
+	defer s.Close()
+
+	for _, test := range data {
+
+		feed, err := itunes.ToRSSClient(test.URL, s.Client())
+
+		if test.Err == nil && err != nil || test.Err != nil && !errors.Is(err, test.Err) {
+			t.Errorf("%s: expected error %v, got %v", test.URL, test.Err, err)
+		}
+
+		if feed != test.Feed {
+			t.Errorf("%s: expected feed %q, got %q", test.URL, test.Feed, feed)
+		}
+	}
+}
+
+func TestServerStatus(t *testing.T) {
+
+	s := itunestest.NewServer(shows...)
+	defer s.Close()
+
+	for _, path := range []string{"/us/podcast/s-town/id1212558767", "/us/podcast/id404"} {
+
+		resp, err := http.Get(s.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusOK {
+			t.Errorf("%s: expected an error status, got %s", path, resp.Status)
+		}
+	}
+}
+
+func TestServerMissingUserAgent(t *testing.T) {
+
+	s := itunestest.NewServer(shows...)
+	defer s.Close()
+
+	client := clientFunc(func(req *http.Request) (*http.Response, error) {
+		req.Header.Set("User-Agent", "Mozilla/5.0")
+		return s.Client().Do(req)
+	})
+
+	_, err := itunes.ToRSSClient("https://itunes.apple.com/us/podcast/serial/id917918570", client)
+	if !errors.Is(err, itunes.ErrNoFeed) {
+		t.Errorf("expected ErrNoFeed, got %v", err)
+	}
+}
+
+func TestServerLookup(t *testing.T) {
+
+	s := itunestest.NewServer(shows...)
+	defer s.Close()
+
+	data := map[string][]int64{
+		"917918570":                          {917918570},
+		"374004085":                          nil,
+		"123":                                nil,
+		"123,1226554692,917918570,374004085": {1226554692, 917918570},
+	}
+
+	for ids, exp := range data {
+
+		resp, err := http.Get(fmt.Sprintf("%s/lookup?id=%s", s.URL, ids))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var v struct {
+			ResultCount int `json:"resultCount"`
+			Results     []struct {
+				CollectionID int64  `json:"collectionId"`
+				FeedURL      string `json:"feedUrl"`
+			} `json:"results"`
+		}
+
+		err = json.NewDecoder(resp.Body).Decode(&v)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("IDs %s: %s", ids, err)
+		}
+
+		if v.ResultCount != len(exp) || len(v.Results) != len(exp) {
+			t.Errorf("IDs %s: expected %d results, got %d", ids, len(exp), len(v.Results))
+			continue
+		}
+
+		for i, id := range exp {
+			if v.Results[i].CollectionID != id {
+				t.Errorf("IDs %s: expected result %d to have collectionId %d, got %d", ids, i, id, v.Results[i].CollectionID)
+			}
+		}
+	}
+}
+
+func TestServerHops(t *testing.T) {
+
+	feed := "http://feeds.serialpodcast.org/serialpodcast"
+	u := "https://itunes.apple.com/us/podcast/serial/id917918570"
+
+	for hops := 0; hops <= 4; hops++ {
+
+		s := itunestest.NewServer(itunestest.Show{
+			ID:   917918570,
+			Feed: feed,
+			Hops: hops,
+		})
+
+		got, err := itunes.ToRSSClient(u, s.Client())
+		s.Close()
+
+		if hops > 3 {
+			if err == nil {
+				t.Errorf("%d hops: expected an error, got feed %q", hops, got)
+			}
+			continue
+		}
+
+		if err != nil || got != feed {
+			t.Errorf("%d hops: expected feed %q, got %q (error %v)", hops, feed, got, err)
+		}
+	}
+}
+
+func TestChain(t *testing.T) {
+
+	feed := "https://example.com/feed?a=1&b=2"
+
+	for depth := 0; depth <= 3; depth++ {
+
+		mux := http.NewServeMux()
+		ts := httptest.NewServer(mux)
+
+		fixtures := itunestest.Chain(ts.URL, feed, depth)
+		if len(fixtures) != depth+1 {
+			t.Fatalf("depth %d: expected %d fixtures, got %d", depth, depth+1, len(fixtures))
+		}
+		mux.Handle("/", itunestest.Handler(fixtures...))
+
+		got, err := itunes.ToRSS(ts.URL + fixtures[0].Path)
+		ts.Close()
+
+		if err != nil || got != feed {
+			t.Errorf("depth %d: expected feed %q, got %q (error %v)", depth, feed, got, err)
+		}
+	}
+}
+
+func TestPage(t *testing.T) {
+
+	feed, err := itunes.ParseHTML(bytes.NewReader(itunestest.Page("Serial", "http://feeds.serialpodcast.org/serialpodcast")))
+	if err != nil || feed != "http://feeds.serialpodcast.org/serialpodcast" {
+		t.Errorf("expected feed, got %q (error %v)", feed, err)
+	}
+
+	_, err = itunes.ParseHTML(bytes.NewReader(itunestest.Page("Serial", "")))
+	if !errors.Is(err, itunes.ErrNoFeed) {
+		t.Errorf("expected ErrNoFeed, got %v", err)
+	}
+}
+
+type clientFunc func(req *http.Request) (*http.Response, error)
+
+func (f clientFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestRecorder(t *testing.T) {
+
+	const (
+		feed      = "http://feeds.serialpodcast.org/serialpodcast"
+		sanitized = "http://feeds.example.com/serialpodcast"
+		url       = "https://itunes.apple.com/us/podcast/serial/id917918570"
+	)
+
+	dir := t.TempDir()
+
+	s := itunestest.NewServer(itunestest.Show{ID: 917918570, Feed: feed, Hops: 1})
+
+	rec := &itunestest.Recorder{
+		Dir:    dir,
+		Client: s.Client(),
+		Sanitize: func(body []byte) []byte {
+			return bytes.Replace(body, []byte(feed), []byte(sanitized), -1)
+		},
+	}
+
+	got, err := itunes.ToRSSClient(url, rec)
+	s.Close()
+
+	if err != nil || got != sanitized {
+		t.Fatalf("recording: expected feed %q, got %q (error %v)", sanitized, got, err)
+	}
+
+	// Replay without the server.
+	rec = &itunestest.Recorder{
+		Dir:        dir,
+		ReplayOnly: true,
+		Client: clientFunc(func(req *http.Request) (*http.Response, error) {
+			t.Errorf("unexpected request for %s", req.URL)
+			return nil, errors.New("offline")
+		}),
+	}
+
+	got, err = itunes.ToRSSClient(url, rec)
+	if err != nil || got != sanitized {
+		t.Errorf("replaying: expected feed %q, got %q (error %v)", sanitized, got, err)
+	}
+
+	_, err = itunes.ToRSSClient("https://itunes.apple.com/us/podcast/id1", rec)
+	if !errors.Is(err, itunestest.ErrNotRecorded) {
+		t.Errorf("expected ErrNotRecorded for an unrecorded URL, got %v", err)
+	}
+}
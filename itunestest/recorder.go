@@ -0,0 +1,171 @@
+package itunestest
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/deepilla/itunes"
+)
+
+// ErrNotRecorded is returned by a Recorder in replay-only mode
+// for requests that it has no response for.
+var ErrNotRecorded = errors.New("no recorded response")
+
+// DefaultRedactHeaders are the response headers that a Recorder
+// removes before recording, unless its RedactHeaders are set.
+// Don't modify it while a Recorder may be in use.
+var DefaultRedactHeaders = []string{
+	"Set-Cookie",
+	"Date",
+	"X-Apple-Request-Uuid",
+	"X-Apple-Jingle-Correlation-Key",
+}
+
+// A Recorder is an itunes.Client that records responses from
+// real servers to disk, then replays them. Use it to write
+// deterministic tests against real Apple responses: run the
+// tests once with network access to record the responses, and
+// commit them. The zero value of every field except Dir is
+// ready to use. A Recorder is safe for concurrent use by
+// multiple goroutines.
+type Recorder struct {
+	// Dir is the directory that holds the recorded responses,
+	// one file per request. It is created if needed.
+	Dir string
+
+	// Client sends the requests that haven't been recorded. If
+	// nil, the default HTTP client is used.
+	Client itunes.Client
+
+	// ReplayOnly makes requests that haven't been recorded fail
+	// with ErrNotRecorded instead of being sent.
+	ReplayOnly bool
+
+	// RedactHeaders are the response headers removed before
+	// recording. If nil, DefaultRedactHeaders are removed.
+	RedactHeaders []string
+
+	// Sanitize, if set, rewrites each response body before it
+	// is recorded, e.g. to remove personal data.
+	Sanitize func(body []byte) []byte
+}
+
+// Do returns the recorded response for req, or sends req and
+// records its response.
+func (r *Recorder) Do(req *http.Request) (*http.Response, error) {
+
+	filename := r.filename(req)
+
+	data, err := ioutil.ReadFile(filename)
+	if err == nil {
+		return http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), req)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if r.ReplayOnly {
+		return nil, fmt.Errorf("%s %s: %w", req.Method, req.URL, ErrNotRecorded)
+	}
+
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Sanitize != nil {
+		body = r.Sanitize(body)
+	}
+
+	redact := r.RedactHeaders
+	if redact == nil {
+		redact = DefaultRedactHeaders
+	}
+	for _, name := range redact {
+		resp.Header.Del(name)
+	}
+
+	// The body is stored as it was received, without any
+	// transfer encoding.
+	resp.Header.Del("Content-Length")
+	resp.TransferEncoding = nil
+	resp.ContentLength = int64(len(body))
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	dump, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(r.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	if err := writeFile(filename, dump); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// writeFile writes data to filename via a temporary file, so
+// that concurrent readers never see part of it.
+func writeFile(filename string, data []byte) error {
+
+	f, err := ioutil.TempFile(filepath.Dir(filename), ".recording-")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+
+	return err
+}
+
+// filename returns the file that holds the response to req.
+// e.g. GET-itunes.apple.com-3f2a9c1b7d4e5f60.http
+func (r *Recorder) filename(req *http.Request) string {
+
+	sum := sha1.Sum([]byte(req.Method + " " + req.URL.String()))
+	host := strings.Map(func(c rune) rune {
+		if c == ':' || c == '/' || c == '\\' {
+			return '_'
+		}
+		return c
+	}, req.URL.Host)
+
+	return filepath.Join(r.Dir, req.Method+"-"+host+"-"+hex.EncodeToString(sum[:8])+".http")
+}
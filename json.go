@@ -0,0 +1,90 @@
+package itunes
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// SchemaVersion is the version of the JSON encoding of Result,
+// Podcast and Episode. It changes when fields are renamed or
+// removed, but not when fields are added.
+//
+// Version 1 encodes a Result as:
+//
+//	{
+//	  "url":         the URL that was resolved,
+//	  "feed":        the RSS feed, if one was found,
+//	  "page":        the canonical iTunes page, if known,
+//	  "error":       the error message, if it failed,
+//	  "duration_ms": the time taken in milliseconds,
+//	  "attempts":    the number of attempts,
+//	  "requests":    the number of HTTP requests,
+//	  "strategy":    the strategy that found the feed, if any,
+//	  "request_id":  the ID of the URL's requests, if any
+//	}
+//
+// Podcasts and Episodes use the lowercase names of their
+// fields, in snake case, e.g. "show_id".
+const SchemaVersion = 1
+
+// resultJSON is the JSON encoding of a Result.
+type resultJSON struct {
+	URL       string `json:"url"`
+	Feed      string `json:"feed,omitempty"`
+	Page      string `json:"page,omitempty"`
+	Err       string `json:"error,omitempty"`
+	Duration  int64  `json:"duration_ms"`
+	Attempts  int    `json:"attempts"`
+	Requests  int    `json:"requests"`
+	Strategy  string `json:"strategy,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// MarshalJSON encodes a Result as described by SchemaVersion.
+func (r Result) MarshalJSON() ([]byte, error) {
+
+	v := resultJSON{
+		URL:       r.URL,
+		Feed:      r.Feed,
+		Page:      r.Page,
+		Duration:  int64(r.Duration / time.Millisecond),
+		Attempts:  r.Attempts,
+		Requests:  r.Requests,
+		Strategy:  r.Strategy,
+		RequestID: r.RequestID,
+	}
+
+	if r.Err != nil {
+		v.Err = r.Err.Error()
+	}
+
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON decodes a Result encoded by MarshalJSON. Only
+// the message of the error survives the round trip.
+func (r *Result) UnmarshalJSON(data []byte) error {
+
+	var v resultJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	*r = Result{
+		URL:       v.URL,
+		Feed:      v.Feed,
+		Page:      v.Page,
+		Duration:  time.Duration(v.Duration) * time.Millisecond,
+		Attempts:  v.Attempts,
+		Requests:  v.Requests,
+		Strategy:  v.Strategy,
+		RequestID: v.RequestID,
+	}
+
+	if v.Err != "" {
+		r.Err = errors.New(v.Err)
+	}
+
+	return nil
+}
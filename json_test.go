@@ -0,0 +1,87 @@
+package itunes_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/deepilla/itunes"
+)
+
+func TestResultJSON(t *testing.T) {
+
+	data := map[string]struct {
+		Result itunes.Result
+		JSON   string
+	}{
+		"success": {
+			Result: itunes.Result{
+				URL:       "https://itunes.apple.com/us/podcast/serial/id917918570",
+				Feed:      "http://feeds.serialpodcast.org/serialpodcast",
+				Page:      "https://itunes.apple.com/us/podcast/id917918570",
+				Duration:  1500 * time.Millisecond,
+				Attempts:  1,
+				Requests:  2,
+				Strategy:  "feed-url attribute",
+				RequestID: "5f2b9c0d1e3a4b67",
+			},
+			JSON: `{"url":"https://itunes.apple.com/us/podcast/serial/id917918570","feed":"http://feeds.serialpodcast.org/serialpodcast","page":"https://itunes.apple.com/us/podcast/id917918570","duration_ms":1500,"attempts":1,"requests":2,"strategy":"feed-url attribute","request_id":"5f2b9c0d1e3a4b67"}`,
+		},
+		"failure": {
+			Result: itunes.Result{
+				URL:      "https://itunes.apple.com/us/podcast/id1",
+				Err:      errors.New("no feed found"),
+				Duration: 20 * time.Millisecond,
+				Attempts: 2,
+			},
+			JSON: `{"url":"https://itunes.apple.com/us/podcast/id1","error":"no feed found","duration_ms":20,"attempts":2,"requests":0}`,
+		},
+	}
+
+	for name, exp := range data {
+
+		b, err := json.Marshal(exp.Result)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", name, err)
+			continue
+		}
+
+		if string(b) != exp.JSON {
+			t.Errorf("%s: expected JSON\n%s\ngot\n%s", name, exp.JSON, b)
+		}
+
+		var r itunes.Result
+		if err := json.Unmarshal(b, &r); err != nil {
+			t.Errorf("%s: unexpected error %s", name, err)
+			continue
+		}
+
+		if r.URL != exp.Result.URL || r.Feed != exp.Result.Feed || r.Page != exp.Result.Page || r.Duration != exp.Result.Duration || r.Attempts != exp.Result.Attempts || r.Requests != exp.Result.Requests || r.Strategy != exp.Result.Strategy || r.RequestID != exp.Result.RequestID {
+			t.Errorf("%s: expected %+v after a round trip, got %+v", name, exp.Result, r)
+		}
+
+		if !equalErrors(r.Err, exp.Result.Err) {
+			t.Errorf("%s: expected error %s, got %s", name, formatError(exp.Result.Err), formatError(r.Err))
+		}
+	}
+}
+
+func TestPodcastJSON(t *testing.T) {
+
+	p := itunes.Podcast{
+		ID:    917918570,
+		Title: "Serial",
+		Feed:  "http://feeds.serialpodcast.org/serialpodcast",
+	}
+
+	b, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `{"id":917918570,"title":"Serial","feed":"http://feeds.serialpodcast.org/serialpodcast"}`
+	if string(b) != exp {
+		t.Errorf("expected JSON\n%s\ngot\n%s", exp, b)
+	}
+}
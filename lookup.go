@@ -0,0 +1,191 @@
+package itunes
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	lookupURL = "https://itunes.apple.com/lookup"
+
+	// The maximum number of IDs per Lookup API request.
+	maxLookupIDs = 200
+)
+
+// Lookup returns the shows with the given iTunes IDs from the
+// iTunes Lookup API, using the provided Client. The IDs are
+// looked up 200 at a time. IDs that aren't found, e.g. shows
+// that are no longer in the store, are left out of the results.
+func Lookup(ids []int64, client Client) ([]Podcast, error) {
+	return lookup(ids, "", client)
+}
+
+// ErrNoEpisode is returned by LookupEpisode for episodes that
+// the Lookup API doesn't return. It only returns a show's 200
+// most recent episodes.
+var ErrNoEpisode = errors.New("episode not found")
+
+// LookupEpisode returns the episode of an Apple episode URL,
+// e.g. https://podcasts.apple.com/us/podcast/serial/id917918570?i=1000431123468,
+// from the iTunes Lookup API. The episode's Enclosure is its
+// audio file, so it can be downloaded without parsing the
+// show's feed.
+func LookupEpisode(episodeURL string, client Client) (Episode, error) {
+
+	show, err := ParseURL(episodeURL)
+	if err != nil {
+		return Episode{}, err
+	}
+
+	u, err := url.Parse(episodeURL)
+	if err != nil {
+		return Episode{}, err
+	}
+
+	id, err := strconv.ParseInt(u.Query().Get("i"), 10, 64)
+	if err != nil {
+		return Episode{}, fmt.Errorf("%q is not an Apple episode URL", episodeURL)
+	}
+
+	q := url.Values{}
+	q.Set("id", show.ID)
+	q.Set("entity", "podcastEpisode")
+	q.Set("limit", strconv.Itoa(maxSearchLimit))
+	if show.Storefront != "" {
+		q.Set("country", show.Storefront)
+	}
+
+	results, err := getResults(client, lookupURL+"?"+q.Encode())
+	if err != nil {
+		return Episode{}, err
+	}
+
+	for _, r := range results {
+		if r.Kind == "podcast-episode" && r.TrackID == id {
+			return r.episode(), nil
+		}
+	}
+
+	return Episode{}, ErrNoEpisode
+}
+
+// Matches the ID in the path of an artist page.
+// e.g. https://podcasts.apple.com/us/artist/serial-productions/1441478263
+var reArtistPath = regexp.MustCompile(`^(?:/([a-z]{2}))?/artist/(?:[^/]+/)?(?:id)?(\d+)$`)
+
+// ArtistPodcasts returns the shows by the creator of an Apple
+// artist page, e.g.
+// https://podcasts.apple.com/us/artist/serial-productions/1441478263,
+// from the iTunes Lookup API.
+func ArtistPodcasts(artistURL string, client Client) ([]Podcast, error) {
+
+	u, err := url.Parse(artistURL)
+	if err != nil {
+		return nil, err
+	}
+
+	m := reArtistPath.FindStringSubmatch(u.Path)
+	if !isAppleHost(u.Hostname()) || m == nil {
+		return nil, fmt.Errorf("%q is not an Apple artist URL", artistURL)
+	}
+
+	id, err := strconv.ParseInt(m[2], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	q := url.Values{}
+	q.Set("entity", "podcast")
+	q.Set("limit", strconv.Itoa(maxSearchLimit))
+	if m[1] != "" {
+		q.Set("country", m[1])
+	}
+
+	return lookupQuery([]int64{id}, q, client)
+}
+
+// DefaultStorefronts are the storefronts probed by
+// ProbeStorefronts if none are given. Don't modify it while
+// ProbeStorefronts may be running.
+var DefaultStorefronts = []string{"us", "gb", "ca", "au", "ie", "nz", "de", "fr", "es", "it", "nl", "se", "br", "mx", "jp", "in"}
+
+// ProbeStorefronts looks up a show in each of the given
+// storefronts, e.g. "us" or "gb", and returns the storefronts
+// that it is available in. It helps to diagnose shows that
+// can't be found: a show that is region-locked is available in
+// some storefronts, but a show that has been removed is
+// available in none. If storefronts is empty,
+// DefaultStorefronts are probed.
+func ProbeStorefronts(id int64, storefronts []string, client Client) ([]string, error) {
+
+	if len(storefronts) == 0 {
+		storefronts = DefaultStorefronts
+	}
+
+	var found []string
+	for _, sf := range storefronts {
+
+		podcasts, err := lookup([]int64{id}, sf, client)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(podcasts) > 0 {
+			found = append(found, sf)
+		}
+	}
+
+	return found, nil
+}
+
+func lookup(ids []int64, country string, client Client) ([]Podcast, error) {
+
+	q := url.Values{}
+	if country != "" {
+		q.Set("country", country)
+	}
+
+	return lookupQuery(ids, q, client)
+}
+
+// lookupQuery looks up ids with the additional query params.
+func lookupQuery(ids []int64, params url.Values, client Client) ([]Podcast, error) {
+
+	query := ""
+	if len(params) > 0 {
+		query = "&" + params.Encode()
+	}
+
+	var podcasts []Podcast
+
+	for len(ids) > 0 {
+
+		n := len(ids)
+		if n > maxLookupIDs {
+			n = maxLookupIDs
+		}
+
+		strs := make([]string, n)
+		for i, id := range ids[:n] {
+			strs[i] = strconv.FormatInt(id, 10)
+		}
+		ids = ids[n:]
+
+		results, err := getResults(client, lookupURL+"?id="+strings.Join(strs, ",")+query)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range results {
+			if r.Kind == "podcast" {
+				podcasts = append(podcasts, r.podcast())
+			}
+		}
+	}
+
+	return podcasts, nil
+}
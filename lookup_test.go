@@ -0,0 +1,143 @@
+package itunes_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/deepilla/itunes"
+	"github.com/deepilla/itunes/itunestest"
+)
+
+func TestLookup(t *testing.T) {
+
+	var shows []itunestest.Show
+	var ids []int64
+	for id := int64(1); id <= 450; id++ {
+		ids = append(ids, id)
+		// Leave out every tenth show.
+		if id%10 != 0 {
+			shows = append(shows, itunestest.Show{ID: id, Feed: fmt.Sprintf("https://example.com/feeds/%d", id)})
+		}
+	}
+
+	s := itunestest.NewServer(shows...)
+	defer s.Close()
+
+	client := &countingClient{Client: s.Client()}
+
+	podcasts, err := itunes.Lookup(ids, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(podcasts) != len(shows) {
+		t.Fatalf("expected %d podcasts, got %d", len(shows), len(podcasts))
+	}
+
+	for i, p := range podcasts {
+		if p.ID != shows[i].ID || p.Feed != shows[i].Feed {
+			t.Errorf("podcast %d: expected ID %d with feed %q, got ID %d with feed %q", i, shows[i].ID, shows[i].Feed, p.ID, p.Feed)
+		}
+	}
+
+	// 450 IDs take three requests.
+	if got := client.Count(); got != 3 {
+		t.Errorf("expected 3 requests, got %d", got)
+	}
+}
+
+func TestProbeStorefronts(t *testing.T) {
+
+	s := itunestest.NewServer(
+		itunestest.Show{ID: 1, Feed: "https://example.com/feeds/1"},
+		itunestest.Show{ID: 2, Feed: "https://example.com/feeds/2", Storefronts: []string{"gb", "ie"}},
+	)
+	defer s.Close()
+
+	storefronts := []string{"us", "gb", "ie", "fr"}
+
+	data := map[int64]string{
+		1: "[us gb ie fr]",
+		2: "[gb ie]",
+		3: "[]",
+	}
+
+	for id, exp := range data {
+
+		found, err := itunes.ProbeStorefronts(id, storefronts, s.Client())
+		if err != nil {
+			t.Fatalf("ID %d: %s", id, err)
+		}
+
+		if got := fmt.Sprint(found); got != exp {
+			t.Errorf("ID %d: expected storefronts %s, got %s", id, exp, got)
+		}
+	}
+}
+
+func TestArtistPodcasts(t *testing.T) {
+
+	s := itunestest.NewServer(
+		itunestest.Show{ID: 917918570, Title: "Serial", Feed: "http://feeds.serialpodcast.org/serialpodcast", ArtistID: 1441478263, Artist: "Serial Productions"},
+		itunestest.Show{ID: 1212558767, Title: "S-Town", Feed: "http://feeds.stownpodcast.org/stownpodcast", ArtistID: 1441478263, Artist: "Serial Productions"},
+		itunestest.Show{ID: 1, Title: "Other", Feed: "https://example.com/feed", ArtistID: 2},
+	)
+	defer s.Close()
+
+	for _, u := range []string{
+		"https://podcasts.apple.com/us/artist/serial-productions/1441478263",
+		"https://itunes.apple.com/artist/id1441478263",
+	} {
+
+		podcasts, err := itunes.ArtistPodcasts(u, s.Client())
+		if err != nil {
+			t.Fatalf("%s: %s", u, err)
+		}
+
+		var got []string
+		for _, p := range podcasts {
+			got = append(got, fmt.Sprintf("%d %s %s", p.ID, p.Title, p.Author))
+		}
+
+		exp := "[917918570 Serial Serial Productions 1212558767 S-Town Serial Productions]"
+		if fmt.Sprint(got) != exp {
+			t.Errorf("%s: expected %s, got %s", u, exp, got)
+		}
+	}
+
+	if _, err := itunes.ArtistPodcasts("https://podcasts.apple.com/us/podcast/serial/id917918570", s.Client()); err == nil {
+		t.Error("expected an error for a show URL")
+	}
+}
+
+func TestLookupEpisode(t *testing.T) {
+
+	s := itunestest.NewServer(itunestest.Show{
+		ID:    917918570,
+		Title: "Serial",
+		Episodes: []itunestest.Episode{
+			{ID: 1001, Title: "Episode 1", Enclosure: "https://example.com/audio/1.mp3"},
+			{ID: 1002, Title: "Episode 2", Enclosure: "https://example.com/audio/2.mp3"},
+		},
+	})
+	defer s.Close()
+
+	ep, err := itunes.LookupEpisode("https://podcasts.apple.com/us/podcast/serial/id917918570?i=1002", s.Client())
+	if err != nil {
+		t.Fatalf("unexpected error %s", formatError(err))
+	}
+
+	if ep.ID != 1002 || ep.ShowID != 917918570 || ep.Title != "Episode 2" || ep.Enclosure != "https://example.com/audio/2.mp3" {
+		t.Errorf("unexpected episode %+v", ep)
+	}
+
+	_, err = itunes.LookupEpisode("https://podcasts.apple.com/us/podcast/serial/id917918570?i=1003", s.Client())
+	if err != itunes.ErrNoEpisode {
+		t.Errorf("expected error %s, got %s", formatError(itunes.ErrNoEpisode), formatError(err))
+	}
+
+	_, err = itunes.LookupEpisode("https://podcasts.apple.com/us/podcast/serial/id917918570", s.Client())
+	if err == nil {
+		t.Errorf("expected an error for a show URL, got nil")
+	}
+}
@@ -0,0 +1,5 @@
+//go:build !race
+
+package itunes_test
+
+const raceEnabled = false
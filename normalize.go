@@ -0,0 +1,110 @@
+package itunes
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Matches an HTML character reference with its semicolon.
+// References without one, like &copy in ?x=1&copy=2, are left
+// alone as they are more likely to be query parameters.
+var reEntity = regexp.MustCompile(`&(?:[A-Za-z]+|#[0-9]+|#[xX][0-9A-Fa-f]+);`)
+
+// normalizeURL cleans up a feed URL extracted from a page so
+// that HTTP clients accept it. It trims whitespace, undoes
+// entity escaping left over from the page, gives protocol-
+// relative URLs an https scheme, lowercases the host, and
+// percent-encodes spaces, control characters and non-ASCII
+// bytes in the path and query, with uppercase hex digits. It
+// also drops any fragment.
+// Hosts are left in Unicode: Go's HTTP client converts them
+// to Punycode itself.
+func normalizeURL(s string) string {
+
+	s = strings.TrimSpace(s)
+
+	// Pages sometimes escape URLs more than once.
+	// e.g. http://example.com/feed?a=1&amp;amp;b=2
+	for i := 0; i < 3 && strings.Contains(s, "&"); i++ {
+		t := reEntity.ReplaceAllStringFunc(s, html.UnescapeString)
+		if t == s {
+			break
+		}
+		s = strings.TrimSpace(t)
+	}
+
+	// e.g. //feeds.serialpodcast.org/serialpodcast
+	if strings.HasPrefix(s, "//") {
+		s = "https:" + s
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+
+	u.Host = strings.ToLower(u.Host)
+
+	path := escape(u.EscapedPath())
+	if p, err := url.PathUnescape(path); err == nil {
+		u.Path, u.RawPath = p, path
+	}
+
+	u.RawQuery = escape(u.RawQuery)
+	u.Fragment = ""
+
+	return u.String()
+}
+
+// checkFeedURL returns an *InvalidFeedError unless s is an
+// absolute http or https URL with a host.
+func checkFeedURL(s string) (string, error) {
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", &InvalidFeedError{URL: s, Reason: err.Error()}
+	}
+
+	switch {
+	case !u.IsAbs():
+		return "", &InvalidFeedError{URL: s, Reason: "not an absolute URL"}
+	case u.Scheme != "http" && u.Scheme != "https":
+		return "", &InvalidFeedError{URL: s, Reason: "unsupported scheme " + u.Scheme}
+	case u.Hostname() == "":
+		return "", &InvalidFeedError{URL: s, Reason: "no host"}
+	}
+
+	return s, nil
+}
+
+// escape percent-encodes the bytes in an already-escaped URL
+// component that aren't valid in a URL, leaving existing
+// escapes as they are apart from uppercasing their hex digits.
+func escape(s string) string {
+
+	const hex = "0123456789ABCDEF"
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]):
+			b.WriteString(strings.ToUpper(s[i : i+3]))
+			i += 2
+		case c <= ' ' || c >= 0x7f || c == '%' || c == '"' || c == '<' || c == '>' || c == '\\' || c == '^' || c == '`' || c == '{' || c == '|' || c == '}':
+			b.WriteByte('%')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&0xf])
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
@@ -0,0 +1,82 @@
+package itunes_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/deepilla/itunes"
+)
+
+func TestFeedURLNormalization(t *testing.T) {
+
+	data := map[string]string{
+		"http://example.com/feed":                     "http://example.com/feed",
+		"  http://example.com/feed\n":                 "http://example.com/feed",
+		"http://EXAMPLE.com/feed":                     "http://example.com/feed",
+		"http://example.com/my feed.xml":              "http://example.com/my%20feed.xml",
+		"http://example.com/café/feed":                "http://example.com/caf%C3%A9/feed",
+		"http://example.com/feed?q=café au lait":      "http://example.com/feed?q=caf%C3%A9%20au%20lait",
+		"http://example.com/feed?a=1&amp;amp;b=2":     "http://example.com/feed?a=1&b=2",
+		"http://example.com/a%2fb/feed?x=%e2%9c%93":   "http://example.com/a%2Fb/feed?x=%E2%9C%93",
+		"http://example.com/feed?pct=100%":            "http://example.com/feed?pct=100%25",
+		"http://example.com/feed#latest":              "http://example.com/feed",
+		"http://example.com/feed?already=%20encoded":  "http://example.com/feed?already=%20encoded",
+		"http://example.com/feed?a=1&amp;amp;amp;b=2": "http://example.com/feed?a=1&b=2",
+		"http://example.com/feed?a=1&amp;#38;b=2":     "http://example.com/feed?a=1&b=2",
+		"http://example.com/feed?a=1&copy=2":          "http://example.com/feed?a=1&copy=2",
+		"&#32;http://example.com/feed&#x20;":          "http://example.com/feed",
+		"//feeds.serialpodcast.org/serialpodcast":     "https://feeds.serialpodcast.org/serialpodcast",
+	}
+
+	for in, exp := range data {
+
+		page := fmt.Sprintf(`<html><body><button feed-url="%s">Subscribe</button></body></html>`, in)
+
+		feed, err := itunes.ParseHTML(strings.NewReader(page))
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", in, err)
+			continue
+		}
+
+		if feed != exp {
+			t.Errorf("%q: expected %q, got %q", in, exp, feed)
+		}
+	}
+}
+
+func TestInvalidFeedURL(t *testing.T) {
+
+	feeds := []string{
+		"javascript:alert(1)",
+		"JavaScript:alert(1)",
+		"data:text/xml;base64,PHJzcz48L3Jzcz4=",
+		"ftp://example.com/feed",
+		"/feeds/serial",
+		"feeds.serialpodcast.org/serialpodcast",
+		"http:///feed",
+		"http://%zz/feed",
+	}
+
+	for _, in := range feeds {
+
+		page := fmt.Sprintf(`<html><body><button feed-url="%s">Subscribe</button></body></html>`, in)
+
+		feed, err := itunes.ParseHTML(strings.NewReader(page))
+
+		var e *itunes.InvalidFeedError
+		if !errors.As(err, &e) {
+			t.Errorf("%q: expected an InvalidFeedError, got feed %q, error %s", in, feed, formatError(err))
+			continue
+		}
+
+		if !errors.Is(err, itunes.ErrNoFeed) {
+			t.Errorf("%q: expected error to match ErrNoFeed", in)
+		}
+
+		if !errors.Is(err, itunes.ErrInvalidFeedURL) {
+			t.Errorf("%q: expected error to match ErrInvalidFeedURL", in)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package itunes
+
+import "bufio"
+
+const (
+	// defaultMaxPageSize is the most that is read from a page
+	// if Options.MaxPageSize is zero. iTunes pages are
+	// typically a few hundred KB.
+	defaultMaxPageSize = 4 << 20
+
+	defaultReadBufferSize         = 4 << 10
+	defaultMaxPlistLine           = bufio.MaxScanTokenSize
+	defaultMaxAmbiguousCandidates = 5
+)
+
+// Options configure how the package reads pages and finds
+// feeds. The package-level functions, like ToRSS and
+// ParseHTML, use the zero value, which is ready to use. Options
+// are passed by value, so they are safe for concurrent use.
+type Options struct {
+	// MaxPageSize is the most that is read from a page, and so
+	// bounds the memory used to parse it. Larger pages fail
+	// with ErrTooLarge. If zero, it defaults to 4 MB.
+	MaxPageSize int64
+
+	// ReadBufferSize is the size of the buffer that ToRSSFile
+	// reads files through. If zero, it defaults to 4 KB. Sizes
+	// below 512 bytes, the most needed to detect a file's
+	// Content Type, are rounded up.
+	ReadBufferSize int
+
+	// MaxPlistLine is the longest line that ParsePlist reads,
+	// and so bounds the memory it uses. Plists with longer
+	// lines fail with bufio.ErrTooLong. If zero, it defaults to
+	// bufio.MaxScanTokenSize.
+	MaxPlistLine int
+
+	// SniffPlainText makes ToRSSReader and the ToRSS functions
+	// look inside bodies served as text/plain, which Apple and
+	// some mirrors occasionally send. Bodies that start with an
+	// XML declaration are processed as plists, and bodies that
+	// start with an HTML doctype or tag as pages. Others still
+	// fail with an *UnexpectedContentTypeError.
+	SniffPlainText bool
+
+	// HarvestFeeds makes ParseHTML and the ToRSS functions
+	// search a page for anything that looks like a feed URL
+	// when every strategy fails. The URLs found are guesses, so
+	// they are reported as the Candidates of the *NoFeedError
+	// rather than as the feed.
+	HarvestFeeds bool
+
+	// FollowSelfReferences makes the ToRSS functions resolve a
+	// feed that is itself the URL of another show, once.
+	// Otherwise, such feeds fail with a *SelfReferenceError.
+	FollowSelfReferences bool
+
+	// MaxAmbiguousCandidates is the most Candidates that an
+	// *AmbiguousError from ResolveByName holds. If zero, it
+	// defaults to 5.
+	MaxAmbiguousCandidates int
+}
+
+func (o Options) maxPageSize() int64 {
+	if o.MaxPageSize <= 0 {
+		return defaultMaxPageSize
+	}
+	return o.MaxPageSize
+}
+
+func (o Options) readBufferSize() int {
+	switch {
+	case o.ReadBufferSize <= 0:
+		return defaultReadBufferSize
+	case o.ReadBufferSize < 512:
+		return 512
+	default:
+		return o.ReadBufferSize
+	}
+}
+
+func (o Options) maxPlistLine() int {
+	if o.MaxPlistLine <= 0 {
+		return defaultMaxPlistLine
+	}
+	return o.MaxPlistLine
+}
+
+func (o Options) maxAmbiguousCandidates() int {
+	if o.MaxAmbiguousCandidates <= 0 {
+		return defaultMaxAmbiguousCandidates
+	}
+	return o.MaxAmbiguousCandidates
+}
@@ -0,0 +1,7 @@
+//go:build race
+
+package itunes_test
+
+// raceEnabled is true when the tests run with the race
+// detector, which changes allocation counts.
+const raceEnabled = true
@@ -0,0 +1,481 @@
+package itunes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	searchURL = "https://itunes.apple.com/search"
+
+	// The default and maximum number of results per request.
+	defaultSearchLimit = 50
+	maxSearchLimit     = 200
+)
+
+// StopSearch can be returned by the callback passed to
+// WalkPodcasts or WalkEpisodes to stop the walk without an
+// error.
+var StopSearch = errors.New("stop search")
+
+// A Podcast is a show returned by the iTunes Search API. Its
+// JSON encoding is part of the schema described by
+// SchemaVersion.
+type Podcast struct {
+	ID      int64  `json:"id"`
+	Title   string `json:"title"`
+	Author  string `json:"author,omitempty"`
+	Feed    string `json:"feed,omitempty"`
+	URL     string `json:"url,omitempty"` // iTunes page
+	Artwork string `json:"artwork,omitempty"`
+}
+
+// An Episode is a podcast episode returned by the iTunes
+// Search API. Its JSON encoding is part of the schema described
+// by SchemaVersion.
+type Episode struct {
+	ID        int64     `json:"id"`
+	Title     string    `json:"title"`
+	ShowID    int64     `json:"show_id"`
+	ShowTitle string    `json:"show_title,omitempty"`
+	Released  time.Time `json:"released"`
+	URL       string    `json:"url,omitempty"`       // iTunes page
+	Enclosure string    `json:"enclosure,omitempty"` // audio file
+}
+
+// ToRSS returns the RSS feed of the episode's show using the
+// provided Client.
+func (e Episode) ToRSS(client Client) (string, error) {
+	return ToRSSClient(showURL(e.ShowID), client)
+}
+
+// showURL returns the iTunes page for a show ID.
+func showURL(id int64) string {
+	return "https://itunes.apple.com/podcast/id" + strconv.FormatInt(id, 10)
+}
+
+// A Search queries the iTunes Search API.
+type Search struct {
+	// Term is the text to search for.
+	Term string
+
+	// Attribute, if set, restricts the search to one field,
+	// e.g. "titleTerm" or "authorTerm".
+	Attribute string
+
+	// Country is the two-letter code of the store to search.
+	// If empty, the US store is searched.
+	Country string
+
+	// Offset is the number of results to skip.
+	Offset int
+
+	// Limit is the number of results to return per request.
+	// If zero, it defaults to 50. The maximum is 200.
+	Limit int
+
+	// Client executes the HTTP requests. If nil, the default
+	// HTTP client is used.
+	Client Client
+}
+
+// searchResult holds the fields of a Search or Lookup API
+// result that are used by Podcasts and Episodes.
+type searchResult struct {
+	WrapperType       string    `json:"wrapperType"`
+	Kind              string    `json:"kind"`
+	CollectionID      int64     `json:"collectionId"`
+	TrackID           int64     `json:"trackId"`
+	CollectionName    string    `json:"collectionName"`
+	TrackName         string    `json:"trackName"`
+	ArtistName        string    `json:"artistName"`
+	FeedURL           string    `json:"feedUrl"`
+	CollectionViewURL string    `json:"collectionViewUrl"`
+	TrackViewURL      string    `json:"trackViewUrl"`
+	ArtworkURL600     string    `json:"artworkUrl600"`
+	EpisodeURL        string    `json:"episodeUrl"`
+	ReleaseDate       time.Time `json:"releaseDate"`
+}
+
+// Podcasts returns the shows that match the search term.
+func (s *Search) Podcasts() ([]Podcast, error) {
+
+	results, err := s.search("podcast", s.Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	podcasts := make([]Podcast, 0, len(results))
+	for _, r := range results {
+		podcasts = append(podcasts, r.podcast())
+	}
+
+	return podcasts, nil
+}
+
+// WalkPodcasts calls fn with each show that matches the search
+// term, paging through the results until there are no more or
+// fn returns an error. If fn returns StopSearch, WalkPodcasts
+// returns nil.
+func (s *Search) WalkPodcasts(fn func(Podcast) error) error {
+	return s.walk("podcast", func(r searchResult) error {
+		return fn(r.podcast())
+	})
+}
+
+// Episodes returns the podcast episodes that match the search
+// term.
+func (s *Search) Episodes() ([]Episode, error) {
+
+	results, err := s.search("podcastEpisode", s.Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	episodes := make([]Episode, 0, len(results))
+	for _, r := range results {
+		episodes = append(episodes, r.episode())
+	}
+
+	return episodes, nil
+}
+
+// WalkEpisodes calls fn with each episode that matches the
+// search term, paging through the results until there are no
+// more or fn returns an error. If fn returns StopSearch,
+// WalkEpisodes returns nil.
+func (s *Search) WalkEpisodes(fn func(Episode) error) error {
+	return s.walk("podcastEpisode", func(r searchResult) error {
+		return fn(r.episode())
+	})
+}
+
+func (r searchResult) podcast() Podcast {
+	return Podcast{
+		ID:      r.CollectionID,
+		Title:   r.CollectionName,
+		Author:  r.ArtistName,
+		Feed:    r.FeedURL,
+		URL:     r.CollectionViewURL,
+		Artwork: r.ArtworkURL600,
+	}
+}
+
+func (r searchResult) episode() Episode {
+	return Episode{
+		ID:        r.TrackID,
+		Title:     r.TrackName,
+		ShowID:    r.CollectionID,
+		ShowTitle: r.CollectionName,
+		Released:  r.ReleaseDate,
+		URL:       r.TrackViewURL,
+		Enclosure: r.EpisodeURL,
+	}
+}
+
+func (s *Search) walk(entity string, fn func(searchResult) error) error {
+
+	offset := s.Offset
+	limit := s.limit()
+
+	for {
+		results, err := s.search(entity, offset)
+		if err != nil {
+			return err
+		}
+
+		for _, r := range results {
+			if err := fn(r); err != nil {
+				if err == StopSearch {
+					return nil
+				}
+				return err
+			}
+		}
+
+		// A short page is the last one.
+		if len(results) < limit {
+			return nil
+		}
+
+		offset += len(results)
+	}
+}
+
+func (s *Search) limit() int {
+	switch {
+	case s.Limit <= 0:
+		return defaultSearchLimit
+	case s.Limit > maxSearchLimit:
+		return maxSearchLimit
+	default:
+		return s.Limit
+	}
+}
+
+func (s *Search) search(entity string, offset int) ([]searchResult, error) {
+
+	q := url.Values{}
+	q.Set("term", s.Term)
+	q.Set("media", "podcast")
+	q.Set("entity", entity)
+	q.Set("limit", strconv.Itoa(s.limit()))
+	if offset > 0 {
+		q.Set("offset", strconv.Itoa(offset))
+	}
+	if s.Attribute != "" {
+		q.Set("attribute", s.Attribute)
+	}
+	if s.Country != "" {
+		q.Set("country", s.Country)
+	}
+
+	return getResults(s.Client, searchURL+"?"+q.Encode())
+}
+
+// getResults returns the results from a Search or Lookup API
+// URL.
+func getResults(client Client, u string) ([]searchResult, error) {
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := fetch(client, u)
+	if err != nil {
+		return nil, &URLError{URL: u, Err: err}
+	}
+	defer closeBody(resp.Body)
+
+	var body struct {
+		Results []searchResult `json:"results"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, &URLError{URL: u, Err: fmt.Errorf("bad JSON: %w", err)}
+	}
+
+	return body.Results, nil
+}
+
+// ErrNoMatch is returned by ResolveByName when a search finds
+// no shows.
+var ErrNoMatch = errors.New("no show found")
+
+// ErrAmbiguous is matched by an *AmbiguousError.
+var ErrAmbiguous = errors.New("ambiguous show title")
+
+// An AmbiguousError is returned by ResolveByName when no show,
+// or more than one show, has the given title. Candidates are
+// the shows that the search found, closest title first, for a
+// user to choose from.
+type AmbiguousError struct {
+	Title      string
+	Candidates []Podcast
+}
+
+func (e *AmbiguousError) Error() string {
+	return fmt.Sprintf("%s %q: %d candidates", ErrAmbiguous, e.Title, len(e.Candidates))
+}
+
+// Is reports whether target is ErrAmbiguous.
+func (e *AmbiguousError) Is(target error) bool {
+	return target == ErrAmbiguous
+}
+
+// maxNameResults is the number of search results that
+// ResolveByName considers.
+const maxNameResults = 10
+
+// ResolveByName returns the RSS feed of the show with the given
+// title. It searches the US store with the iTunes Search API
+// and resolves the one show whose title matches, ignoring case,
+// punctuation and a leading "The". If no show matches, or more
+// than one does, it returns an *AmbiguousError with the shows
+// found. If client is nil, the default HTTP client is used.
+// The requests are cancelled if ctx is done.
+func ResolveByName(ctx context.Context, title string, client Client) (string, error) {
+	return Options{}.ResolveByName(ctx, title, client)
+}
+
+// ResolveByName is like the package-level ResolveByName but
+// uses the Options.
+func (o Options) ResolveByName(ctx context.Context, title string, client Client) (string, error) {
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	client = contextClient{ctx: ctx, client: client}
+
+	s := &Search{
+		Term:   title,
+		Limit:  maxNameResults,
+		Client: client,
+	}
+
+	podcasts, err := s.Podcasts()
+	if err != nil {
+		return "", err
+	}
+
+	if len(podcasts) == 0 {
+		return "", ErrNoMatch
+	}
+
+	want := titleKey(title)
+
+	var matches []Podcast
+	for _, p := range podcasts {
+		if titleKey(p.Title) == want {
+			matches = append(matches, p)
+		}
+	}
+
+	switch len(matches) {
+	case 1:
+	case 0:
+		return "", newAmbiguousError(title, podcasts, o.maxAmbiguousCandidates())
+	default:
+		return "", newAmbiguousError(title, matches, o.maxAmbiguousCandidates())
+	}
+
+	p := matches[0]
+	if feed, err := checkFeedURL(p.Feed); err == nil && !isSelfReference(feed) {
+		return feed, nil
+	}
+
+	// The API doesn't know the feed, so try the show's page.
+	return o.ToRSSClient(showURL(p.ID), client)
+}
+
+// newAmbiguousError returns an *AmbiguousError with the top
+// max candidates, ranked by how many words of their titles
+// they share with title.
+func newAmbiguousError(title string, candidates []Podcast, max int) *AmbiguousError {
+
+	want := strings.Fields(titleKey(title))
+
+	scores := make(map[int64]float64, len(candidates))
+	for _, p := range candidates {
+		scores[p.ID] = overlap(want, strings.Fields(titleKey(p.Title)))
+	}
+
+	ranked := append([]Podcast(nil), candidates...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return scores[ranked[i].ID] > scores[ranked[j].ID]
+	})
+
+	if len(ranked) > max {
+		ranked = ranked[:max]
+	}
+
+	return &AmbiguousError{Title: title, Candidates: ranked}
+}
+
+// overlap returns the fraction of the words in a and b that
+// are in both.
+func overlap(a, b []string) float64 {
+
+	if len(a)+len(b) == 0 {
+		return 0
+	}
+
+	in := map[string]bool{}
+	for _, w := range a {
+		in[w] = true
+	}
+
+	shared := 0
+	for _, w := range b {
+		if in[w] {
+			shared++
+			delete(in, w)
+		}
+	}
+
+	return float64(2*shared) / float64(len(a)+len(b))
+}
+
+// titleKey returns a form of a show title for comparison. It
+// ignores case, accents, apostrophes, other punctuation and a
+// leading "The", and treats "&" as "and".
+// e.g. "The Daily!" and "daily" have the same key, as do
+// "Kermode & Mayo's Film Review" and "Kermode and Mayo’s Film
+// Review".
+func titleKey(title string) string {
+
+	var b strings.Builder
+	for _, r := range strings.ToLower(title) {
+		switch {
+		case r == '\'' || r == '’' || r == 'ʼ':
+		case r == '&':
+			b.WriteString(" and ")
+		case unicode.Is(unicode.Mn, r):
+			// Drop combining accents from decomposed text.
+		case foldedRunes[r] != "":
+			b.WriteString(foldedRunes[r])
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	words := strings.FieldsFunc(b.String(), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	if len(words) > 1 && words[0] == "the" {
+		words = words[1:]
+	}
+
+	return strings.Join(words, " ")
+}
+
+// foldedRunes maps lowercase accented Latin letters to their
+// unaccented spellings.
+var foldedRunes = func() map[rune]string {
+
+	m := map[rune]string{
+		'ß': "ss",
+		'æ': "ae",
+		'œ': "oe",
+		'þ': "th",
+		'ð': "d",
+	}
+
+	for base, accented := range map[string]string{
+		"a": "àáâãäåāăą",
+		"c": "çćĉċč",
+		"d": "ďđ",
+		"e": "èéêëēĕėęě",
+		"g": "ĝğġģ",
+		"h": "ĥħ",
+		"i": "ìíîïĩīĭįı",
+		"j": "ĵ",
+		"k": "ķ",
+		"l": "ĺļľŀł",
+		"n": "ñńņň",
+		"o": "òóôõöøōŏő",
+		"r": "ŕŗř",
+		"s": "śŝşšș",
+		"t": "ţťŧț",
+		"u": "ùúûüũūŭůűų",
+		"w": "ŵ",
+		"y": "ýÿŷ",
+		"z": "źżž",
+	} {
+		for _, r := range accented {
+			m[r] = base
+		}
+	}
+
+	return m
+}()
@@ -0,0 +1,339 @@
+package itunes_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deepilla/itunes"
+	"github.com/deepilla/itunes/itunestest"
+)
+
+const episodeResults = `{"resultCount":2,"results":[
+{"wrapperType":"podcastEpisode","kind":"podcast-episode","trackId":1001,"trackName":"Episode One","collectionId":1,"collectionName":"Show 1","releaseDate":"2017-03-28T10:00:00Z","trackViewUrl":"https://itunes.apple.com/us/podcast/episode-one/id1?i=1001"},
+{"wrapperType":"podcastEpisode","kind":"podcast-episode","trackId":1002,"trackName":"Episode Two","collectionId":2,"collectionName":"Show 2","releaseDate":"2017-04-04T10:00:00Z","trackViewUrl":"https://itunes.apple.com/us/podcast/episode-two/id2?i=1002"}
+]}`
+
+const podcastResults = `{"resultCount":1,"results":[
+{"wrapperType":"track","kind":"podcast","collectionId":1,"trackId":1,"collectionName":"Show 1","artistName":"Someone","feedUrl":"https://example.com/feeds/1","collectionViewUrl":"https://itunes.apple.com/us/podcast/show-1/id1?mt=2","artworkUrl600":"https://example.com/1.jpg"}
+]}`
+
+func newSearchServer(t *testing.T) (*httptest.Server, itunes.Client) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.URL.Path == "/search" {
+			if r.URL.Query().Get("term") != "test" || r.URL.Query().Get("media") != "podcast" {
+				http.Error(w, "bad query", http.StatusBadRequest)
+				return
+			}
+			switch r.URL.Query().Get("entity") {
+			case "podcastEpisode":
+				fmt.Fprint(w, episodeResults)
+			case "podcast":
+				fmt.Fprint(w, podcastResults)
+			default:
+				http.Error(w, "bad entity", http.StatusBadRequest)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		w.Write(itunestest.Page("Show", "https://example.com/feeds"+r.URL.Path))
+	}))
+
+	client := redirectHosts(ts)
+
+	return ts, client
+}
+
+func TestSearchPodcasts(t *testing.T) {
+
+	ts, client := newSearchServer(t)
+	defer ts.Close()
+
+	s := &itunes.Search{
+		Term:   "test",
+		Client: client,
+	}
+
+	podcasts, err := s.Podcasts()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []itunes.Podcast{
+		{
+			ID:      1,
+			Title:   "Show 1",
+			Author:  "Someone",
+			Feed:    "https://example.com/feeds/1",
+			URL:     "https://itunes.apple.com/us/podcast/show-1/id1?mt=2",
+			Artwork: "https://example.com/1.jpg",
+		},
+	}
+
+	if len(podcasts) != len(exp) {
+		t.Fatalf("expected %d podcasts, got %d", len(exp), len(podcasts))
+	}
+
+	for i := range exp {
+		if podcasts[i] != exp[i] {
+			t.Errorf("podcast %d: expected %+v, got %+v", i, exp[i], podcasts[i])
+		}
+	}
+}
+
+func TestSearchEpisodes(t *testing.T) {
+
+	ts, client := newSearchServer(t)
+	defer ts.Close()
+
+	s := &itunes.Search{
+		Term:   "test",
+		Client: client,
+	}
+
+	episodes, err := s.Episodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []struct {
+		ID       int64
+		Title    string
+		ShowID   int64
+		Released time.Time
+		Feed     string
+	}{
+		{1001, "Episode One", 1, time.Date(2017, 3, 28, 10, 0, 0, 0, time.UTC), "https://example.com/feeds/podcast/id1"},
+		{1002, "Episode Two", 2, time.Date(2017, 4, 4, 10, 0, 0, 0, time.UTC), "https://example.com/feeds/podcast/id2"},
+	}
+
+	if len(episodes) != len(exp) {
+		t.Fatalf("expected %d episodes, got %d", len(exp), len(episodes))
+	}
+
+	for i, e := range exp {
+
+		ep := episodes[i]
+
+		if ep.ID != e.ID || ep.Title != e.Title || ep.ShowID != e.ShowID {
+			t.Errorf("episode %d: expected %d %q from show %d, got %d %q from show %d", i, e.ID, e.Title, e.ShowID, ep.ID, ep.Title, ep.ShowID)
+		}
+
+		if !ep.Released.Equal(e.Released) {
+			t.Errorf("episode %d: expected release date %s, got %s", i, e.Released, ep.Released)
+		}
+
+		feed, err := ep.ToRSS(client)
+		if err != nil {
+			t.Errorf("episode %d: unexpected error %s", i, err)
+			continue
+		}
+
+		if feed != e.Feed {
+			t.Errorf("episode %d: expected feed %q, got %q", i, e.Feed, feed)
+		}
+	}
+}
+
+func TestSearchWalk(t *testing.T) {
+
+	const total = 7
+
+	var queries []url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		q := r.URL.Query()
+		queries = append(queries, q)
+
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		offset, _ := strconv.Atoi(q.Get("offset"))
+
+		var results []string
+		for i := offset + 1; i <= total && i <= offset+limit; i++ {
+			results = append(results, fmt.Sprintf(`{"collectionId":%d,"collectionName":"Show %d"}`, i, i))
+		}
+
+		fmt.Fprintf(w, `{"resultCount":%d,"results":[%s]}`, len(results), strings.Join(results, ","))
+	}))
+	defer ts.Close()
+
+	base, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &itunes.Search{
+		Term:      "test",
+		Attribute: "titleTerm",
+		Country:   "gb",
+		Offset:    1,
+		Limit:     2,
+		Client: clientFunc(func(req *http.Request) (*http.Response, error) {
+			req.URL.Scheme = base.Scheme
+			req.URL.Host = base.Host
+			return http.DefaultClient.Do(req)
+		}),
+	}
+
+	var ids []int64
+	err = s.WalkPodcasts(func(p itunes.Podcast) error {
+		ids = append(ids, p.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, exp := fmt.Sprint(ids), "[2 3 4 5 6 7]"; got != exp {
+		t.Errorf("expected IDs %s, got %s", exp, got)
+	}
+
+	// Offsets 1, 3 and 5 return full pages, offset 7 is empty.
+	if len(queries) != 4 {
+		t.Errorf("expected 4 requests, got %d", len(queries))
+	}
+
+	for i, q := range queries {
+		if q.Get("attribute") != "titleTerm" || q.Get("country") != "gb" || q.Get("limit") != "2" {
+			t.Errorf("request %d: unexpected query %v", i, q)
+		}
+	}
+
+	// StopSearch ends the walk early without an error.
+	ids, queries = nil, nil
+	err = s.WalkPodcasts(func(p itunes.Podcast) error {
+		ids = append(ids, p.ID)
+		if len(ids) == 3 {
+			return itunes.StopSearch
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ids) != 3 || len(queries) != 2 {
+		t.Errorf("expected 3 IDs from 2 requests, got %d IDs from %d requests", len(ids), len(queries))
+	}
+}
+
+func TestResolveByName(t *testing.T) {
+
+	results := map[string]string{
+		"serial": `{"results":[
+{"kind":"podcast","collectionId":2,"collectionName":"Serial Killers","feedUrl":"https://example.com/feeds/2"},
+{"kind":"podcast","collectionId":1,"collectionName":"Serial","feedUrl":"https://example.com/feeds/1"}
+]}`,
+		"s-town": `{"results":[
+{"kind":"podcast","collectionId":3,"collectionName":"S-Town"}
+]}`,
+		"the daily": `{"results":[
+{"kind":"podcast","collectionId":4,"collectionName":"The Daily","feedUrl":"https://example.com/feeds/4"},
+{"kind":"podcast","collectionId":5,"collectionName":"Daily!","feedUrl":"https://example.com/feeds/5"}
+]}`,
+		"fuzzy": `{"results":[
+{"kind":"podcast","collectionId":6,"collectionName":"Something Else","feedUrl":"https://example.com/feeds/6"}
+]}`,
+		"film review": `{"results":[
+{"kind":"podcast","collectionId":7,"collectionName":"Cinema Weekly","artistName":"A","artworkUrl600":"https://example.com/7.jpg"},
+{"kind":"podcast","collectionId":8,"collectionName":"Kermode and Mayo's Film Review","artistName":"B","artworkUrl600":"https://example.com/8.jpg"},
+{"kind":"podcast","collectionId":9,"collectionName":"The Film Review Show","artistName":"C","artworkUrl600":"https://example.com/9.jpg"}
+]}`,
+		"nothing": `{"results":[]}`,
+		"Kermode & Mayo’s Film Review": `{"results":[
+{"kind":"podcast","collectionId":10,"collectionName":"Kermode and Mayo's Film Review","feedUrl":"https://example.com/feeds/10"}
+]}`,
+		"cafe societe": `{"results":[
+{"kind":"podcast","collectionId":11,"collectionName":"Café Société","feedUrl":"https://example.com/feeds/11"},
+{"kind":"podcast","collectionId":12,"collectionName":"Cafe\u0301 Socie\u0301te\u0301 Extra","feedUrl":"https://example.com/feeds/12"}
+]}`,
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.URL.Path == "/search" {
+			fmt.Fprint(w, results[r.URL.Query().Get("term")])
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		w.Write(itunestest.Page("Show", "https://example.com/feeds"+r.URL.Path))
+	}))
+	defer ts.Close()
+
+	client := redirectHosts(ts)
+
+	data := []struct {
+		Title      string
+		Options    itunes.Options
+		Feed       string
+		Err        error
+		Candidates []int64
+	}{
+		{Title: "serial", Feed: "https://example.com/feeds/1"},
+		// S-Town has no feed in the API, so its page is used.
+		{Title: "s-town", Feed: "https://example.com/feeds/podcast/id3"},
+		{Title: "the daily", Err: itunes.ErrAmbiguous, Candidates: []int64{4, 5}},
+		{Title: "fuzzy", Err: itunes.ErrAmbiguous, Candidates: []int64{6}},
+		// Closest titles first.
+		{Title: "film review", Err: itunes.ErrAmbiguous, Candidates: []int64{9, 8, 7}},
+		{Title: "film review", Options: itunes.Options{MaxAmbiguousCandidates: 2}, Err: itunes.ErrAmbiguous, Candidates: []int64{9, 8}},
+		{Title: "nothing", Err: itunes.ErrNoMatch},
+		// Punctuation, apostrophes and accents are ignored.
+		{Title: "Kermode & Mayo’s Film Review", Feed: "https://example.com/feeds/10"},
+		{Title: "cafe societe", Feed: "https://example.com/feeds/11"},
+	}
+
+	for _, test := range data {
+
+		feed, err := test.Options.ResolveByName(context.Background(), test.Title, client)
+
+		if !equalErrors(err, test.Err) {
+			t.Errorf("%q: expected error %s, got %s", test.Title, formatError(test.Err), formatError(err))
+		}
+
+		if feed != test.Feed {
+			t.Errorf("%q: expected feed %q, got %q", test.Title, test.Feed, feed)
+		}
+
+		var e *itunes.AmbiguousError
+		if !errors.As(err, &e) {
+			continue
+		}
+
+		var ids []int64
+		for _, p := range e.Candidates {
+			ids = append(ids, p.ID)
+			if p.Title == "" || p.ID == 0 {
+				t.Errorf("%q: expected candidates with titles and IDs, got %+v", test.Title, p)
+			}
+		}
+
+		if fmt.Sprint(ids) != fmt.Sprint(test.Candidates) {
+			t.Errorf("%q: expected candidates %v, got %v", test.Title, test.Candidates, ids)
+		}
+	}
+}
+
+// redirectHosts returns a Client that sends every request to
+// the test server, whatever its host.
+func redirectHosts(ts *httptest.Server) itunes.Client {
+
+	base, _ := url.Parse(ts.URL)
+
+	return clientFunc(func(req *http.Request) (*http.Response, error) {
+		req.URL.Scheme = base.Scheme
+		req.URL.Host = base.Host
+		return http.DefaultClient.Do(req)
+	})
+}
@@ -0,0 +1,338 @@
+package itunes
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// scanTags tokenizes body from the tag containing the first
+// occurrence of marker and calls fn with the name and
+// attributes of each start tag until fn returns true. It
+// returns the value found by fn, or errNotFound.
+func scanTags(body, marker []byte, errNotFound error, fn func(tag []byte, attrs map[string]string) (string, bool)) (string, error) {
+
+	body = preFilter(body, marker)
+	if body == nil {
+		return "", errNotFound
+	}
+
+	z := html.NewTokenizer(bytes.NewReader(body))
+
+	for {
+		tt := z.Next()
+
+		if tt == html.ErrorToken {
+			break
+		}
+
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+
+		tag, hasAttrs := z.TagName()
+
+		attrs := make(map[string]string)
+		for hasAttrs {
+			var attr, val []byte
+			attr, val, hasAttrs = z.TagAttr()
+			attrs[string(attr)] = string(val)
+		}
+
+		if s, ok := fn(tag, attrs); ok {
+			return s, nil
+		}
+	}
+
+	if err := z.Err(); err != io.EOF {
+		return "", err
+	}
+
+	return "", errNotFound
+}
+
+var errNoFeedLink = errors.New("no RSS alternate link")
+
+var attrRSSType = []byte("application/rss+xml")
+
+// extractFeedLink finds the RSS feed in the alternate link of
+// an AMP page.
+// e.g. <link rel="alternate" type="application/rss+xml" href="http://feeds.serialpodcast.org/serialpodcast">
+func extractFeedLink(body []byte) (string, error) {
+	return scanTags(body, attrRSSType, errNoFeedLink, func(tag []byte, attrs map[string]string) (string, bool) {
+
+		if string(tag) != "link" || attrs["href"] == "" {
+			return "", false
+		}
+
+		if !strings.EqualFold(attrs["type"], "application/rss+xml") {
+			return "", false
+		}
+
+		for _, rel := range strings.Fields(attrs["rel"]) {
+			if strings.EqualFold(rel, "alternate") {
+				return attrs["href"], true
+			}
+		}
+
+		return "", false
+	})
+}
+
+var errNoFeedAttr = errors.New("no element with a feed attribute")
+
+var (
+	markerFeed = []byte("feed")
+
+	// feedAttrs are the attributes that hold the feed on
+	// other generations of pages, in order of preference.
+	feedAttrs = []string{"data-feed-url", "data-feedurl", "feed-url", "feedurl", "data-feed"}
+)
+
+// extractFeedAttr finds the RSS feed in a feed attribute on
+// any element. Mobile pages put it on the subscribe link
+// instead of a button, and later generations of pages renamed
+// the attribute.
+// e.g. <a class="subscribe" data-feed-url="http://feeds.serialpodcast.org/serialpodcast">
+func extractFeedAttr(body []byte) (string, error) {
+	return scanTags(body, markerFeed, errNoFeedAttr, func(tag []byte, attrs map[string]string) (string, bool) {
+		for _, name := range feedAttrs {
+			if feed := attrs[name]; feed != "" {
+				return feed, true
+			}
+		}
+		return "", false
+	})
+}
+
+// scanScripts is like scanTags but calls fn with the
+// attributes and contents of each script element.
+func scanScripts(body, marker []byte, errNotFound error, fn func(attrs map[string]string, text []byte) (string, bool)) (string, error) {
+
+	body = preFilter(body, marker)
+	if body == nil {
+		return "", errNotFound
+	}
+
+	z := html.NewTokenizer(bytes.NewReader(body))
+
+	var script map[string]string
+
+	for {
+		tt := z.Next()
+
+		switch tt {
+		case html.ErrorToken:
+			if err := z.Err(); err != io.EOF {
+				return "", err
+			}
+			return "", errNotFound
+
+		case html.StartTagToken:
+			tag, hasAttrs := z.TagName()
+			if string(tag) != "script" {
+				continue
+			}
+			script = make(map[string]string)
+			for hasAttrs {
+				var attr, val []byte
+				attr, val, hasAttrs = z.TagAttr()
+				script[string(attr)] = string(val)
+			}
+
+		case html.TextToken:
+			if script == nil {
+				continue
+			}
+			if s, ok := fn(script, z.Text()); ok {
+				return s, nil
+			}
+			script = nil
+
+		default:
+			script = nil
+		}
+	}
+}
+
+// findJSONString returns the first non-empty string value for
+// key in a decoded JSON value, searching depth first. String
+// values that hold JSON objects or arrays are decoded and
+// searched too, as some pages embed JSON as escaped strings.
+func findJSONString(v interface{}, key string) string {
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if s, ok := v[key].(string); ok && s != "" {
+			return s
+		}
+		// Search the keys in order for a predictable result.
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if s := findJSONString(v[k], key); s != "" {
+				return s
+			}
+		}
+
+	case []interface{}:
+		for _, e := range v {
+			if s := findJSONString(e, key); s != "" {
+				return s
+			}
+		}
+
+	case string:
+		s := strings.TrimSpace(v)
+		if s == "" || s[0] != '{' && s[0] != '[' {
+			return ""
+		}
+		var inner interface{}
+		if json.Unmarshal([]byte(s), &inner) == nil {
+			return findJSONString(inner, key)
+		}
+	}
+
+	return ""
+}
+
+var errNoShoebox = errors.New("no shoebox script with a feed URL")
+
+var markerShoebox = []byte("fastboot/shoebox")
+
+// extractShoebox finds the RSS feed in the "shoebox" JSON of
+// archived pages from the Ember generation of Apple's web app.
+// The shoebox maps API URLs to their responses, which are JSON
+// encoded as strings.
+// e.g. <script type="fastboot/shoebox" id="shoebox-media-api-cache-amp-podcasts">{"...":"{\"d\":[{\"attributes\":{\"feedUrl\":\"...\"}}]}"}</script>
+func extractShoebox(body []byte) (string, error) {
+	return scanScripts(body, markerShoebox, errNoShoebox, func(attrs map[string]string, text []byte) (string, bool) {
+
+		if attrs["type"] != string(markerShoebox) {
+			return "", false
+		}
+
+		var v interface{}
+		if err := json.Unmarshal(text, &v); err != nil {
+			return "", false
+		}
+
+		feed := findJSONString(v, "feedUrl")
+		return feed, feed != ""
+	})
+}
+
+var errNoServerData = errors.New("no serialized-server-data script with a feed URL")
+
+var markerServerData = []byte("serialized-server-data")
+
+// extractServerData finds the RSS feed in the serialized
+// server data of current Apple Podcasts pages, which is
+// where they keep their data when fetched with a browser's
+// User Agent. The feed is nested deep inside the page's
+// shelves, so it is found by key rather than by path.
+// e.g. <script type="application/json" id="serialized-server-data">[{"data":{"shelves":[...{"feedUrl":"..."}...]}}]</script>
+func extractServerData(body []byte) (string, error) {
+	return scanScripts(body, markerServerData, errNoServerData, func(attrs map[string]string, text []byte) (string, bool) {
+
+		if attrs["id"] != string(markerServerData) {
+			return "", false
+		}
+
+		var v interface{}
+		if err := json.Unmarshal(text, &v); err != nil {
+			return "", false
+		}
+
+		feed := findJSONString(v, "feedUrl")
+		return feed, feed != ""
+	})
+}
+
+// maxCandidates is the most candidates that harvestFeeds
+// returns.
+const maxCandidates = 10
+
+// Matches an absolute URL in the text of a page.
+var reHarvestURL = regexp.MustCompile(`https?://[^\s"'<>()\\]+`)
+
+// feedPatterns score the parts of a URL that suggest a feed.
+var feedPatterns = []struct {
+	re    *regexp.Regexp
+	score int
+}{
+	{regexp.MustCompile(`(?i)libsyn\.com/rss`), 4},
+	{regexp.MustCompile(`(?i)^https?://feeds?\d*\.`), 3},
+	{regexp.MustCompile(`(?i)feedburner\.com/`), 3},
+	{regexp.MustCompile(`(?i)\.(?:rss|xml)(?:$|\?)`), 2},
+	{regexp.MustCompile(`(?i)[^/]/(?:feed|rss)(?:s|/|$|\?|\.)`), 2},
+	{regexp.MustCompile(`(?i)podcast`), 1},
+}
+
+// harvestFeeds returns the URLs in body that look like feeds,
+// most likely first. URLs on Apple's hosts are ignored.
+func harvestFeeds(body []byte) []string {
+
+	type candidate struct {
+		url   string
+		score int
+	}
+
+	var candidates []candidate
+	seen := map[string]bool{}
+
+	for _, m := range reHarvestURL.FindAll(body, -1) {
+
+		// Drop punctuation that ends a sentence.
+		// e.g. Subscribe at http://feeds.feedburner.com/show.
+		m = bytes.TrimRight(m, ".,;:!?")
+
+		feed, err := checkFeedURL(normalizeURL(string(m)))
+		if err != nil || seen[feed] {
+			continue
+		}
+		seen[feed] = true
+
+		u, err := url.Parse(feed)
+		if err != nil || isAppleHost(u.Hostname()) || strings.HasSuffix(u.Hostname(), "mzstatic.com") {
+			continue
+		}
+
+		score := 0
+		for _, p := range feedPatterns {
+			if p.re.MatchString(feed) {
+				score += p.score
+			}
+		}
+
+		// A podcast mention alone isn't enough.
+		if score > 1 {
+			candidates = append(candidates, candidate{feed, score})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+
+	if len(candidates) > maxCandidates {
+		candidates = candidates[:maxCandidates]
+	}
+
+	urls := make([]string, len(candidates))
+	for i, c := range candidates {
+		urls[i] = c.url
+	}
+
+	return urls
+}
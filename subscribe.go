@@ -0,0 +1,80 @@
+package itunes
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Subscribe schemes open a feed in a podcast app.
+const (
+	SchemePodcast  = "podcast"  // podcast://example.com/feed
+	SchemeITPC     = "itpc"     // itpc://example.com/feed
+	SchemePCast    = "pcast"    // pcast://example.com/feed
+	SchemeOvercast = "overcast" // overcast://x-callback-url/add?url=https://example.com/feed
+)
+
+const overcastPrefix = "overcast://x-callback-url/add?url="
+
+// ToSubscribeURL returns a link that subscribes to an http or
+// https feed with the given subscribe scheme. Apart from
+// Overcast's, subscribe links replace the feed's scheme, so
+// they don't record whether it was http or https.
+func ToSubscribeURL(feed, scheme string) (string, error) {
+
+	u, err := url.Parse(feed)
+	if err != nil {
+		return "", err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("feed %q is not an http or https URL", feed)
+	}
+
+	switch scheme {
+	case SchemePodcast, SchemeITPC, SchemePCast:
+		u.Scheme = scheme
+		return u.String(), nil
+
+	case SchemeOvercast:
+		return overcastPrefix + url.QueryEscape(feed), nil
+
+	default:
+		return "", fmt.Errorf("unsupported subscribe scheme %q", scheme)
+	}
+}
+
+// FromSubscribeURL returns the feed from a subscribe link made
+// with any of the subscribe schemes, or with feed://. Links
+// that don't include the feed's scheme, like podcast://host/path,
+// are assumed to be http, which every podcast server supports.
+func FromSubscribeURL(s string) (string, error) {
+
+	if strings.HasPrefix(s, overcastPrefix) {
+		feed, err := url.QueryUnescape(s[len(overcastPrefix):])
+		if err != nil {
+			return "", err
+		}
+		return checkFeedURL(feed)
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", err
+	}
+
+	switch u.Scheme {
+	case SchemePodcast, SchemeITPC, SchemePCast, "feed":
+	default:
+		return "", fmt.Errorf("unsupported subscribe scheme %q", u.Scheme)
+	}
+
+	// Some links wrap the whole feed URL,
+	// e.g. feed:https://example.com/feed
+	if rest := s[len(u.Scheme)+1:]; strings.HasPrefix(rest, "http://") || strings.HasPrefix(rest, "https://") {
+		return checkFeedURL(rest)
+	}
+
+	u.Scheme = "http"
+	return checkFeedURL(u.String())
+}
@@ -0,0 +1,74 @@
+package itunes_test
+
+import (
+	"testing"
+
+	"github.com/deepilla/itunes"
+)
+
+func TestToSubscribeURL(t *testing.T) {
+
+	const feed = "https://feeds.example.com/show?format=mp3"
+
+	data := map[string]string{
+		itunes.SchemePodcast:  "podcast://feeds.example.com/show?format=mp3",
+		itunes.SchemeITPC:     "itpc://feeds.example.com/show?format=mp3",
+		itunes.SchemePCast:    "pcast://feeds.example.com/show?format=mp3",
+		itunes.SchemeOvercast: "overcast://x-callback-url/add?url=https%3A%2F%2Ffeeds.example.com%2Fshow%3Fformat%3Dmp3",
+	}
+
+	for scheme, exp := range data {
+
+		got, err := itunes.ToSubscribeURL(feed, scheme)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", scheme, err)
+			continue
+		}
+
+		if got != exp {
+			t.Errorf("%s: expected %q, got %q", scheme, exp, got)
+		}
+	}
+
+	if _, err := itunes.ToSubscribeURL(feed, "zune"); err == nil {
+		t.Error("expected an error for an unknown scheme")
+	}
+
+	if _, err := itunes.ToSubscribeURL("javascript:alert(1)", itunes.SchemePodcast); err == nil {
+		t.Error("expected an error for a non-http feed")
+	}
+}
+
+func TestFromSubscribeURL(t *testing.T) {
+
+	data := map[string]string{
+		"podcast://feeds.example.com/show":                                         "http://feeds.example.com/show",
+		"itpc://feeds.example.com/show?format=mp3":                                 "http://feeds.example.com/show?format=mp3",
+		"pcast://feeds.example.com/show":                                           "http://feeds.example.com/show",
+		"feed://feeds.example.com/show":                                            "http://feeds.example.com/show",
+		"feed:https://feeds.example.com/show":                                      "https://feeds.example.com/show",
+		"overcast://x-callback-url/add?url=https%3A%2F%2Ffeeds.example.com%2Fshow": "https://feeds.example.com/show",
+	}
+
+	for s, exp := range data {
+
+		got, err := itunes.FromSubscribeURL(s)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", s, err)
+			continue
+		}
+
+		if got != exp {
+			t.Errorf("%s: expected %q, got %q", s, exp, got)
+		}
+	}
+
+	for _, s := range []string{
+		"https://feeds.example.com/show",
+		"overcast://x-callback-url/add?url=javascript%3Aalert(1)",
+	} {
+		if _, err := itunes.FromSubscribeURL(s); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package itunes
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// Matches the ID in the path of an iTunes podcast page URL.
+	// Other pages, like apps and albums, have IDs too.
+	// e.g. https://itunes.apple.com/us/podcast/serial/id917918570
+	rePathID = regexp.MustCompile(`/podcast/(?:[^/]+/)?id(\d+)$`)
+
+	// Matches a numeric ID in a query string.
+	reQueryID = regexp.MustCompile(`^\d+$`)
+
+	// Matches the storefront at the start of a path.
+	reStorefront = regexp.MustCompile(`^/([a-z]{2})/`)
+)
+
+var errNotShowURL = errors.New("not an Apple podcast URL")
+
+// ErrAppleMusicOnly is returned for music.apple.com URLs, such
+// as radio stations and albums, that aren't podcasts.
+var ErrAppleMusicOnly = errors.New("Apple Music item is not a podcast")
+
+// Matches a podcast path on music.apple.com.
+// e.g. /us/podcast/serial/id917918570
+var reMusicPodcast = regexp.MustCompile(`^(?:/([a-z]{2}))?/podcast/(?:[^/]+/)?id(\d+)$`)
+
+// ErrUnsupportedKind is matched by an *UnsupportedKindError
+// via errors.Is.
+var ErrUnsupportedKind = errors.New("unsupported kind of page")
+
+// An UnsupportedKindError is returned for Apple Podcasts URLs,
+// such as stations and playlists, that collect shows or
+// episodes instead of being a show.
+type UnsupportedKindError struct {
+	Kind string // e.g. "station"
+}
+
+func (e *UnsupportedKindError) Error() string {
+	return ErrUnsupportedKind.Error() + ": " + e.Kind
+}
+
+// Is reports whether target is ErrUnsupportedKind.
+func (e *UnsupportedKindError) Is(target error) bool {
+	return target == ErrUnsupportedKind
+}
+
+// Matches the kind in the path of a station or playlist URL.
+// e.g. /us/station/news/idsa.1a2b3c4d or /us/playlist/daily/pl.1a2b3c4d
+var reUnsupportedKind = regexp.MustCompile(`^(?:/[a-z]{2})?/(station|playlist)(?:/|$)`)
+
+// podcastsURL maps a music.apple.com podcast URL to its iTunes
+// page. It returns ErrAppleMusicOnly for other music.apple.com
+// URLs, an *UnsupportedKindError for Apple Podcasts station and
+// playlist URLs, and other URLs unchanged.
+func podcastsURL(s string) (string, error) {
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return s, nil
+	}
+
+	if strings.ToLower(u.Hostname()) != "music.apple.com" {
+		if m := reUnsupportedKind.FindStringSubmatch(u.Path); m != nil && isAppleHost(u.Hostname()) {
+			return "", &UnsupportedKindError{Kind: m[1]}
+		}
+		return s, nil
+	}
+
+	m := reMusicPodcast.FindStringSubmatch(u.Path)
+	if m == nil {
+		return "", ErrAppleMusicOnly
+	}
+
+	return ShowURL{
+		ID:         m[2],
+		Storefront: m[1],
+		Language:   u.Query().Get("l"),
+	}.String(), nil
+}
+
+// A ShowURL is the information in an Apple podcast URL.
+type ShowURL struct {
+	// ID is the show's iTunes ID.
+	ID string
+
+	// Storefront is the country code in the URL's path, e.g.
+	// "us", if there is one.
+	Storefront string
+
+	// Language is the URL's l= parameter, e.g. "es", if there
+	// is one. It selects the language of the page's metadata.
+	Language string
+}
+
+// ParseURL returns the information in an Apple podcast URL,
+// such as https://itunes.apple.com/us/podcast/serial/id917918570
+// or a WebObjects viewPodcast URL.
+func ParseURL(s string) (ShowURL, error) {
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return ShowURL{}, err
+	}
+
+	if !isAppleHost(u.Hostname()) {
+		return ShowURL{}, errNotShowURL
+	}
+
+	q := u.Query()
+
+	var show ShowURL
+	if m := rePathID.FindStringSubmatch(u.Path); m != nil {
+		show.ID = m[1]
+	} else if id := q.Get("id"); reViewPodcast.MatchString(u.Path) && reQueryID.MatchString(id) {
+		// e.g. https://itunes.apple.com/WebObjects/DZR.woa/wa/viewPodcast?id=917918570
+		show.ID = id
+	} else {
+		return ShowURL{}, errNotShowURL
+	}
+
+	if m := reStorefront.FindStringSubmatch(u.Path); m != nil {
+		show.Storefront = m[1]
+	}
+
+	show.Language = q.Get("l")
+
+	return show, nil
+}
+
+// String returns the show's iTunes page URL, keeping its
+// storefront and language.
+func (u ShowURL) String() string {
+
+	s := "https://itunes.apple.com"
+	if u.Storefront != "" {
+		s += "/" + u.Storefront
+	}
+
+	s += "/podcast/id" + u.ID
+
+	if u.Language != "" {
+		s += "?l=" + url.QueryEscape(u.Language)
+	}
+
+	return s
+}
+
+// affiliateParams are the query parameters used by Apple's
+// affiliate program: the affiliate token, the campaign token
+// and the media type.
+var affiliateParams = map[string]bool{
+	"at": true,
+	"ct": true,
+	"mt": true,
+}
+
+// StripAffiliate returns an Apple URL without its affiliate
+// parameters (at=, ct= and mt=). Other parameters are kept in
+// order.
+func StripAffiliate(s string) (string, error) {
+	return setAffiliate(s, "", "")
+}
+
+// AddAffiliate returns an Apple URL tagged with an affiliate
+// token and, if set, a campaign token. Any existing affiliate
+// parameters are replaced.
+func AddAffiliate(s, token, campaign string) (string, error) {
+
+	if token == "" {
+		return "", errors.New("no affiliate token")
+	}
+
+	return setAffiliate(s, token, campaign)
+}
+
+func setAffiliate(s, token, campaign string) (string, error) {
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", err
+	}
+
+	if !isAppleHost(u.Hostname()) {
+		return "", errNotShowURL
+	}
+
+	var params []string
+	for _, p := range strings.Split(u.RawQuery, "&") {
+		key := p
+		if i := strings.IndexByte(p, '='); i >= 0 {
+			key = p[:i]
+		}
+		if p != "" && !affiliateParams[key] {
+			params = append(params, p)
+		}
+	}
+
+	if token != "" {
+		params = append(params, "at="+url.QueryEscape(token))
+	}
+	if campaign != "" {
+		params = append(params, "ct="+url.QueryEscape(campaign))
+	}
+
+	u.RawQuery = strings.Join(params, "&")
+	u.ForceQuery = false
+
+	return u.String(), nil
+}
+
+// storefrontIDs maps country codes to Apple's storefront IDs.
+var storefrontIDs = map[string]int{
+	"us": 143441,
+	"fr": 143442,
+	"de": 143443,
+	"gb": 143444,
+	"ie": 143449,
+	"it": 143450,
+	"nl": 143452,
+	"es": 143454,
+	"ca": 143455,
+	"se": 143456,
+	"au": 143460,
+	"nz": 143461,
+	"jp": 143462,
+	"in": 143467,
+	"mx": 143468,
+	"br": 143503,
+}
+
+// Matches the path of a WebObjects viewPodcast URL.
+// e.g. /WebObjects/DZR.woa/wa/viewPodcast
+var reViewPodcast = regexp.MustCompile(`^/WebObjects/[A-Za-z]+\.woa/wa/viewPodcast$`)
+
+// storefrontHeader returns the X-Apple-Store-Front header for
+// a WebObjects viewPodcast URL, or "" for other URLs. These
+// URLs carry no storefront in their path, so iTunes reads it
+// from the header. It comes from the cc= parameter, if known,
+// and defaults to the US store.
+func storefrontHeader(u *url.URL) string {
+
+	if !isAppleHost(u.Hostname()) || !reViewPodcast.MatchString(u.Path) {
+		return ""
+	}
+
+	id, ok := storefrontIDs[strings.ToLower(u.Query().Get("cc"))]
+	if !ok {
+		id = storefrontIDs["us"]
+	}
+
+	return strconv.Itoa(id) + ",12"
+}
@@ -0,0 +1,234 @@
+package itunes_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/deepilla/itunes"
+	"github.com/deepilla/itunes/itunestest"
+)
+
+func TestParseURL(t *testing.T) {
+
+	data := map[string]struct {
+		URL    itunes.ShowURL
+		String string
+		Error  bool
+	}{
+		"https://itunes.apple.com/us/podcast/serial/id917918570?mt=2": {
+			URL:    itunes.ShowURL{ID: "917918570", Storefront: "us"},
+			String: "https://itunes.apple.com/us/podcast/id917918570",
+		},
+		"https://podcasts.apple.com/mx/podcast/serial/id917918570?l=en&mt=2": {
+			URL:    itunes.ShowURL{ID: "917918570", Storefront: "mx", Language: "en"},
+			String: "https://itunes.apple.com/mx/podcast/id917918570?l=en",
+		},
+		"https://itunes.apple.com/WebObjects/DZR.woa/wa/viewPodcast?id=917918570&l=es": {
+			URL:    itunes.ShowURL{ID: "917918570", Language: "es"},
+			String: "https://itunes.apple.com/podcast/id917918570?l=es",
+		},
+		"https://example.com/us/podcast/serial/id917918570": {
+			Error: true,
+		},
+		"https://itunes.apple.com/us/podcast/serial": {
+			Error: true,
+		},
+		// Other Apple pages have IDs too.
+		"https://apps.apple.com/us/app/foo/id123": {
+			Error: true,
+		},
+		"https://itunes.apple.com/us/app/foo/id123?mt=8": {
+			Error: true,
+		},
+		"https://music.apple.com/us/album/foo/id123": {
+			Error: true,
+		},
+		"https://itunes.apple.com/WebObjects/MZStore.woa/wa/viewSoftware?id=123": {
+			Error: true,
+		},
+	}
+
+	for s, exp := range data {
+
+		u, err := itunes.ParseURL(s)
+
+		if got := err != nil; got != exp.Error {
+			t.Errorf("%s: expected error %t, got %v", s, exp.Error, err)
+			continue
+		}
+
+		if u != exp.URL {
+			t.Errorf("%s: expected %+v, got %+v", s, exp.URL, u)
+		}
+
+		if exp.String != "" && u.String() != exp.String {
+			t.Errorf("%s: expected String %q, got %q", s, exp.String, u.String())
+		}
+	}
+}
+
+func TestAffiliate(t *testing.T) {
+
+	data := []struct {
+		URL      string
+		Token    string
+		Campaign string
+		Exp      string
+	}{
+		{
+			URL: "https://itunes.apple.com/us/podcast/serial/id917918570?mt=2&at=10l3Vy&ct=blog",
+			Exp: "https://itunes.apple.com/us/podcast/serial/id917918570",
+		},
+		{
+			URL: "https://itunes.apple.com/us/podcast/serial/id917918570?l=es&mt=2&i=1000",
+			Exp: "https://itunes.apple.com/us/podcast/serial/id917918570?l=es&i=1000",
+		},
+		{
+			URL:   "https://itunes.apple.com/us/podcast/serial/id917918570?mt=2&at=old",
+			Token: "new",
+			Exp:   "https://itunes.apple.com/us/podcast/serial/id917918570?at=new",
+		},
+		{
+			URL:      "https://podcasts.apple.com/us/podcast/serial/id917918570?l=es",
+			Token:    "10l3Vy",
+			Campaign: "spring sale",
+			Exp:      "https://podcasts.apple.com/us/podcast/serial/id917918570?l=es&at=10l3Vy&ct=spring+sale",
+		},
+	}
+
+	for _, d := range data {
+
+		var got string
+		var err error
+		if d.Token == "" {
+			got, err = itunes.StripAffiliate(d.URL)
+		} else {
+			got, err = itunes.AddAffiliate(d.URL, d.Token, d.Campaign)
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", d.URL, err)
+			continue
+		}
+
+		if got != d.Exp {
+			t.Errorf("%s: expected %q, got %q", d.URL, d.Exp, got)
+		}
+	}
+
+	if _, err := itunes.StripAffiliate("https://example.com/?at=1"); err == nil {
+		t.Error("expected an error for a non-Apple URL")
+	}
+}
+
+func TestAppleMusicURLs(t *testing.T) {
+
+	const feed = "http://feeds.serialpodcast.org/serialpodcast"
+
+	s := itunestest.NewServer(itunestest.Show{ID: 917918570, Feed: feed})
+	defer s.Close()
+
+	data := map[string]struct {
+		Feed string
+		Err  error
+	}{
+		"https://music.apple.com/us/podcast/serial/id917918570": {
+			Feed: feed,
+		},
+		"https://music.apple.com/podcast/id917918570?l=es": {
+			Feed: feed,
+		},
+		"https://music.apple.com/us/station/beats-1/ra.978194965": {
+			Err: itunes.ErrAppleMusicOnly,
+		},
+		"https://music.apple.com/us/album/in-rainbows/1109714933": {
+			Err: itunes.ErrAppleMusicOnly,
+		},
+	}
+
+	for u, exp := range data {
+
+		got, err := itunes.ToRSSClient(u, s.Client())
+
+		if !equalErrors(err, exp.Err) {
+			t.Errorf("%s: expected error %s, got %s", u, formatError(exp.Err), formatError(err))
+		}
+
+		if got != exp.Feed {
+			t.Errorf("%s: expected feed %q, got %q", u, exp.Feed, got)
+		}
+	}
+}
+
+func TestUnsupportedKinds(t *testing.T) {
+
+	data := map[string]string{
+		"https://podcasts.apple.com/us/station/news/idsa.1a2b3c4d": "station",
+		"https://podcasts.apple.com/playlist/daily/pl.1a2b3c4d":    "playlist",
+	}
+
+	for u, kind := range data {
+
+		client := clientFunc(func(req *http.Request) (*http.Response, error) {
+			t.Fatalf("%s: unexpected request for %s", u, req.URL)
+			return nil, nil
+		})
+
+		_, err := itunes.ToRSSClient(u, client)
+
+		var e *itunes.UnsupportedKindError
+		if !errors.As(err, &e) || e.Kind != kind {
+			t.Errorf("%s: expected an UnsupportedKindError for %q, got %s", u, kind, formatError(err))
+		}
+
+		if !errors.Is(err, itunes.ErrUnsupportedKind) {
+			t.Errorf("%s: expected error to match ErrUnsupportedKind", u)
+		}
+	}
+}
+
+func TestViewPodcastURLs(t *testing.T) {
+
+	const feed = "http://feeds.serialpodcast.org/serialpodcast"
+
+	s := itunestest.NewServer(itunestest.Show{ID: 917918570, Feed: feed})
+	defer s.Close()
+
+	data := map[string]string{
+		"https://itunes.apple.com/WebObjects/DZR.woa/wa/viewPodcast?id=917918570":           "143441,12",
+		"https://itunes.apple.com/WebObjects/MZStore.woa/wa/viewPodcast?cc=gb&id=917918570": "143444,12",
+		"https://itunes.apple.com/us/podcast/serial/id917918570":                            "",
+	}
+
+	for u, exp := range data {
+
+		var headers []string
+		client := clientFunc(func(req *http.Request) (*http.Response, error) {
+			headers = append(headers, req.Header.Get("X-Apple-Store-Front"))
+			return s.Client().Do(req)
+		})
+
+		got, err := itunes.ToRSSClient(u, client)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", u, formatError(err))
+			continue
+		}
+
+		if got != feed {
+			t.Errorf("%s: expected feed %q, got %q", u, feed, got)
+		}
+
+		// The header is only sent with the first request. The
+		// show's page has its storefront in the path.
+		if len(headers) == 0 || headers[0] != exp {
+			t.Errorf("%s: expected X-Apple-Store-Front %q, got %q", u, exp, headers)
+		}
+
+		for _, h := range headers[1:] {
+			if h != "" {
+				t.Errorf("%s: unexpected X-Apple-Store-Front %q", u, h)
+			}
+		}
+	}
+}